@@ -106,4 +106,18 @@ Encode an imagePullSecret string.
 {{- printf "{\"auths\": {\"%s\": {\"auth\": \"%s\"}}}" .Values.imageCredentials.registry (printf "%s:%s" .Values.imageCredentials.username .Values.imageCredentials.password | b64enc) | b64enc }}
 {{- end }}
 
+{{/*
+Name of the Secret with the credentials of the stats page, the MarkLogic chart creates it with the same name
+*/}}
+{{- define "haproxy.statsSecretName" -}}
+{{- default (printf "%s-haproxy-stats" .Release.Name) .Values.stats.auth.secretName }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap the MarkLogic bootstrap host publishes the CA of the App Servers to
+*/}}
+{{- define "haproxy.caConfigMapName" -}}
+{{- printf "%s-haproxy-ca" .Release.Name }}
+{{- end }}
+
 {{/* vim: set filetype=mustache: */}}
@@ -148,6 +148,8 @@ app.kubernetes.io/instance: {{ .Release.Name }}
 {{- define "marklogic.annotations" -}}
 marklogic.com/group-name: {{ .Values.group.name | quote }}
 marklogic.com/group-xdqp-enabled: {{ .Values.group.enableXdqpSsl | quote }}
+marklogic.com/xdqp-custom-certificate: {{ not (empty .Values.group.xdqpSsl.certSecretName) | quote }}
+marklogic.com/tls-enabled: {{ .Values.tls.enableOnDefaultAppServers | quote }}
 marklogic.com/cluster-name: {{ include "marklogic.clusterName" . }}
 app.kubernetes.io/name: "marklogic"
 marklogic.com/fqdn: {{ include "marklogic.fqdn" . }}
@@ -158,7 +160,7 @@ marklogic.com/fqdn: {{ include "marklogic.fqdn" . }}
 {{- if ne $bootStrapHost "" -}}
 {{ .Values.bootstrapHostName }}
 {{- else -}}
-{{ include "marklogic.fqdn" . }}
+{{ include "marklogic.fqdn" (omit . "Group") }}
 {{- end }}
 {{- end }}
 
@@ -192,13 +194,150 @@ Use the auth.secretName value if set, otherwise use the name from marklogic.auth
 {{- end }}
 {{- end }}
 
+{{/*
+Get the name of the secret holding the license.
+Use the license.secretName value if set, otherwise the secret created by the chart when a license key is given.
+*/}}
+{{- define "marklogic.licenseSecretName" -}}
+{{- if .Values.license.secretName }}
+{{- .Values.license.secretName }}
+{{- else if .Values.license.key }}
+{{- printf "%s-license" (include "marklogic.fullname" .) }}
+{{- end }}
+{{- end }}
+
 {{/*
 Fully qualified domain name
 */}}
 {{- define "marklogic.fqdn" -}}
-{{- printf "%s-0.%s.%s.svc.%s" (include "marklogic.fullname" .) (include "marklogic.headlessServiceName" .) .Release.Namespace .Values.clusterDomain }}
+{{- printf "%s-0.%s.%s.svc.%s" (include "marklogic.statefulSetName" .) (include "marklogic.headlessServiceName" .) .Release.Namespace .Values.clusterDomain }}
 {{- end}}
 
+{{/*
+Name of the StatefulSet. The group of group.name uses the full name, the context of an entry of groups
+carries its group as Group, whose StatefulSet is named after the release and the group.
+*/}}
+{{- define "marklogic.statefulSetName" -}}
+{{- if hasKey . "Group" }}
+{{- .Group.statefulSetName }}
+{{- else }}
+{{- include "marklogic.fullname" . }}
+{{- end }}
+{{- end }}
+
+{{/*
+Groups of the release as a JSON array: the group of group.name, which holds the bootstrap host, followed by the
+entries of groups, with the name of their StatefulSet, their replica count and the App Servers HAProxy routes to them.
+*/}}
+{{- define "marklogic.groups" -}}
+{{- $fullname := include "marklogic.fullname" . }}
+{{- $groups := list (dict "name" .Values.group.name "primary" true "statefulSetName" $fullname "replicaCount" (int .Values.replicaCount) "appServers" (.Values.group.appServers | default list)) }}
+{{- range .Values.groups }}
+{{- $replicas := ternary .replicaCount $.Values.replicaCount (hasKey . "replicaCount") }}
+{{- $groups = append $groups (dict "name" .name "primary" false "statefulSetName" (printf "%s-%s" $fullname (lower .name)) "replicaCount" (int $replicas) "appServers" (.appServers | default list)) }}
+{{- end }}
+{{- toJson $groups }}
+{{- end }}
+
+{{/*
+Values of an entry of groups: the values of the release overridden by the settings of the entry.
+Takes the root context as root and the entry as group, returns YAML.
+*/}}
+{{- define "marklogic.groupValues" -}}
+{{- $group := .group }}
+{{- $values := mergeOverwrite (deepCopy .root.Values) (pick $group "resources" "persistence" "nodeSelector" "affinity" "topologySpreadConstraints" "priorityClassName") }}
+{{- if hasKey $group "replicaCount" }}
+{{- $_ := set $values "replicaCount" $group.replicaCount }}
+{{- end }}
+{{- $_ := set $values.group "name" $group.name }}
+{{- if hasKey $group "enableXdqpSsl" }}
+{{- $_ := set $values.group "enableXdqpSsl" $group.enableXdqpSsl }}
+{{- end }}
+{{- $_ := set $values.tls "certSecretNames" ($group.certSecretNames | default list) }}
+{{- toYaml $values }}
+{{- end }}
+
+{{/*
+Environment of the hosts of an entry of groups, overriding the configmap of the release:
+they join the cluster of the group of group.name with the replica count and XDQP SSL of their group.
+*/}}
+{{- define "marklogic.groupEnv" -}}
+- name: MARKLOGIC_CLUSTER_TYPE
+  value: "non-bootstrap"
+- name: MARKLOGIC_BOOTSTRAP_HOST
+  value: {{ include "marklogic.clusterName" . }}
+- name: XDQP_SSL_ENABLED
+  value: {{ .Values.group.enableXdqpSsl | quote }}
+- name: MARKLOGIC_REPLICAS
+  value: {{ .Values.replicaCount | quote }}
+{{- end }}
+
+{{/*
+Selector of the StatefulSet of a group: the selector labels of the release and the group, so the StatefulSet
+of group.name does not select the hosts of the entries of groups. The selector of a StatefulSet can not change,
+a StatefulSet of group.name created without the group keeps its selector, and the release can not add groups.
+*/}}
+{{- define "marklogic.groupSelectorLabels" -}}
+{{- include "marklogic.selectorLabels" . }}
+{{- $existing := dict }}
+{{- if .Group.primary }}
+{{- $existing = lookup "apps/v1" "StatefulSet" .Release.Namespace .Group.statefulSetName }}
+{{- end }}
+{{- if and $existing (not (hasKey $existing.spec.selector.matchLabels "marklogic.com/group")) }}
+{{- if .Values.groups }}
+{{- fail (printf "The StatefulSet %s was created without the group in its selector and would select the hosts of groups. Recreate the release to add groups." .Group.statefulSetName) }}
+{{- end }}
+{{- else }}
+marklogic.com/group: {{ .Group.name | lower }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate groups
+*/}}
+{{- define "marklogic.checkGroups" -}}
+{{- $names := list (lower .Values.group.name) }}
+{{- range .Values.groups }}
+{{- if not .name }}
+{{- fail "Every entry of groups requires a name." }}
+{{- end }}
+{{- if has (lower .name) $names }}
+{{- fail (printf "The group %s is defined more than once in group.name and groups." .name) }}
+{{- end }}
+{{- if not (regexMatch "^[a-z0-9]([-a-z0-9]*[a-z0-9])?$" (lower .name)) }}
+{{- fail (printf "The group %s of groups must consist of alphanumeric characters or '-' to name its StatefulSet." .name) }}
+{{- end }}
+{{- $names = append $names (lower .name) }}
+{{- end }}
+{{- $appServers := list "appservices" "admin" "manage" }}
+{{- range .Values.haproxy.additionalAppServers }}
+{{- $appServers = append $appServers .name }}
+{{- end }}
+{{- range .Values.haproxy.tcpports.ports }}
+{{- $appServers = append $appServers .name }}
+{{- end }}
+{{- $routed := list }}
+{{- range $group := include "marklogic.groups" . | fromJsonArray }}
+{{- $fqdn := printf "%s-0.%s" $group.statefulSetName (include "marklogic.headlessURL" $) }}
+{{- if and (gt (len $fqdn) 64) (not $.Values.allowLongHostnames) }}
+{{- fail (printf "The FQDN: %s of the group %s is longer than 64. Please use a shorter release or group name and try again, or set allowLongHostnames: true in your Helm values file." $fqdn $group.name) }}
+{{- end }}
+{{- range $group.appServers }}
+{{- if not (has . $appServers) }}
+{{- fail (printf "The group %s routes the App Server %s, which is not one of %s." $group.name . (join ", " $appServers)) }}
+{{- end }}
+{{- end }}
+{{- $routed = concat $routed ($group.appServers | default $appServers) }}
+{{- end }}
+{{- if .Values.haproxy.enabled }}
+{{- range $appServers }}
+{{- if not (has . $routed) }}
+{{- fail (printf "No group routes the App Server %s, add it to the appServers of a group." .) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
 {{/*
 Validate values file
 */}}
@@ -210,6 +349,439 @@ Validate values file
 {{- end }}
 {{- end }}
 
+{{/*
+Bounded retries of poststart-hook.sh and reconcile.sh as JSON, rendered into the scripts.
+retries and interval are N_RETRY and RETRY_INTERVAL of the restart checks and curl_retry_validate, readyRetries the
+waits of wait_local_host_ready every interval, joinRetries and groupRetries the waits of join_cluster for the bootstrap
+host and the group every joinInterval, tlsPause the pauses of configure_tls.
+*/}}
+{{- define "marklogic.bootstrapRetries" -}}
+{{- toJson (dict "retries" 10 "interval" 5 "readyRetries" 30 "joinRetries" 5 "groupRetries" 10 "joinInterval" 10 "tlsPause" 5) }}
+{{- end }}
+
+{{/*
+Longest time in seconds a single bounded retry loop of poststart-hook.sh waits before it gives up and reports the
+failure. It is not a bound on the bootstrap: a joining host also waits for the bootstrap host, and the reconcilers and
+post-bootstrap hooks take as long as MarkLogic needs to apply them.
+*/}}
+{{- define "marklogic.longestBootstrapRetry" -}}
+{{- $retries := include "marklogic.bootstrapRetries" . | fromJson }}
+{{- max (mul $retries.retries $retries.interval) (mul $retries.readyRetries $retries.interval) (mul $retries.joinRetries $retries.joinInterval) (mul $retries.groupRetries $retries.joinInterval) }}
+{{- end }}
+
+{{/*
+Validate that the probes let each retry loop of poststart-hook.sh give up and report its failure before the kubelet
+restarts the container. The startup probe holds off the liveness probe until bootstrap finishes, so only one of them
+is checked.
+*/}}
+{{- define "marklogic.checkProbeTimings" -}}
+{{- $longest := int (include "marklogic.longestBootstrapRetry" .) }}
+{{- if .Values.startupProbe.enabled }}
+{{- $window := add .Values.startupProbe.initialDelaySeconds (mul .Values.startupProbe.periodSeconds .Values.startupProbe.failureThreshold) }}
+{{- if lt (int $window) $longest }}
+{{- $errorMessage := printf "The startup probe allows %d seconds (initialDelaySeconds + periodSeconds * failureThreshold) but a retry loop of the bootstrap can wait up to %d seconds. Please increase startupProbe.failureThreshold or startupProbe.periodSeconds." (int $window) $longest }}
+{{- fail $errorMessage }}
+{{- end }}
+{{- else if .Values.livenessProbe.enabled }}
+{{- $window := add .Values.livenessProbe.initialDelaySeconds (mul .Values.livenessProbe.periodSeconds .Values.livenessProbe.failureThreshold) }}
+{{- if lt (int $window) $longest }}
+{{- $errorMessage := printf "The startup probe is disabled and the liveness probe allows %d seconds (initialDelaySeconds + periodSeconds * failureThreshold) but a retry loop of the bootstrap can wait up to %d seconds. Please enable startupProbe or increase livenessProbe.initialDelaySeconds." (int $window) $longest }}
+{{- fail $errorMessage }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the post-bootstrap hooks
+*/}}
+{{- define "marklogic.checkPostBootstrapHooks" -}}
+{{- if not (has (.Values.hooks.failurePolicy | default "Ignore") (list "Ignore" "Fail")) }}
+{{- fail (printf "hooks.failurePolicy %q is invalid. Supported policies are Ignore and Fail." (toString .Values.hooks.failurePolicy)) }}
+{{- end }}
+{{- $names := list }}
+{{- range .Values.hooks.postBootstrap }}
+{{- if not (regexMatch "^[a-z0-9]([-a-z0-9]*[a-z0-9])?$" (toString .name)) }}
+{{- fail (printf "The post-bootstrap hook name %q is invalid. Hook names must consist of lower case alphanumeric characters or '-'." (toString .name)) }}
+{{- end }}
+{{- if has .name $names }}
+{{- fail (printf "The post-bootstrap hook name %q is used more than once." .name) }}
+{{- end }}
+{{- $names = append $names .name }}
+{{- if or (not .configMap) (not .key) }}
+{{- fail (printf "The post-bootstrap hook %q must set configMap and key." .name) }}
+{{- end }}
+{{- if not (has .type (list "script" "manage")) }}
+{{- fail (printf "The post-bootstrap hook %q has type %q. Supported types are script and manage." .name (toString .type)) }}
+{{- end }}
+{{- if and (eq .type "manage") (not (hasPrefix "/manage/" (toString .path))) }}
+{{- fail (printf "The post-bootstrap hook %q of type manage must set a path starting with /manage/." .name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the App Servers of tls.appServers
+*/}}
+{{- define "marklogic.checkAppServerTls" -}}
+{{- if and .Values.tls.appServers (not .Values.tls.enableOnDefaultAppServers) }}
+{{- fail "tls.appServers requires tls.enableOnDefaultAppServers to be true, which creates the certificates of the hosts." }}
+{{- end }}
+{{- $servers := list }}
+{{- range .Values.tls.appServers }}
+{{- if or (not .name) (not .port) }}
+{{- fail "Each of tls.appServers must set name and port." }}
+{{- end }}
+{{- $server := printf "%s|%s" .name (.group | default $.Values.group.name) }}
+{{- if has $server $servers }}
+{{- fail (printf "The App Server %s is listed more than once in tls.appServers." .name) }}
+{{- end }}
+{{- $servers = append $servers $server }}
+{{- if and .clientCertRequired (not $.Values.tls.clientAuth.caSecretName) }}
+{{- fail (printf "The App Server %s requires client certificates, which requires tls.clientAuth.caSecretName." .name) }}
+{{- end }}
+{{- if and (eq (.authentication | default "") "certificate") (not .clientCertRequired) }}
+{{- fail (printf "The App Server %s uses certificate authentication, which requires clientCertRequired." .name) }}
+{{- end }}
+{{- end }}
+{{- $clientAuthPorts := include "marklogic.clientAuthPorts" . | fromJsonArray }}
+{{- if .Values.haproxy.enabled }}
+{{- range .Values.haproxy.additionalAppServers }}
+{{- if has (printf "%v" (.targetPort | default .port)) $clientAuthPorts }}
+{{- if $.Values.haproxy.pathbased.enabled }}
+{{- fail (printf "The App Server on port %v requires client certificates, which HAProxy can not verify with path based routing." (.targetPort | default .port)) }}
+{{- end }}
+{{- if not (has $.Values.haproxy.clientAuth.mode (list "passthrough" "terminate")) }}
+{{- fail (printf "haproxy.clientAuth.mode is %q. It must be passthrough or terminate." (toString $.Values.haproxy.clientAuth.mode)) }}
+{{- end }}
+{{- if and (eq $.Values.haproxy.clientAuth.mode "terminate") (not $.Values.haproxy.tls.enabled) }}
+{{- fail "haproxy.clientAuth.mode terminate requires haproxy.tls.enabled to be true." }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the TLS policy
+*/}}
+{{- define "marklogic.checkTlsPolicy" -}}
+{{- $policy := .Values.tls.policy | default "" }}
+{{- if not (has $policy (list "" "modern" "intermediate" "custom")) }}
+{{- fail (printf "tls.policy is %q. It must be modern, intermediate or custom." (toString $policy)) }}
+{{- end }}
+{{- if eq $policy "custom" }}
+{{- if not (has .Values.tls.customPolicy.minVersion (list "TLSv1.0" "TLSv1.1" "TLSv1.2" "TLSv1.3")) }}
+{{- fail (printf "tls.customPolicy.minVersion is %q. It must be TLSv1.0, TLSv1.1, TLSv1.2 or TLSv1.3." (toString .Values.tls.customPolicy.minVersion)) }}
+{{- end }}
+{{- if not .Values.tls.customPolicy.ciphers }}
+{{- fail "tls.policy custom requires tls.customPolicy.ciphers." }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the credentials of the HAProxy stats page
+*/}}
+{{- define "marklogic.checkHAProxyStats" -}}
+{{- $auth := .Values.haproxy.stats.auth }}
+{{- if and .Values.haproxy.enabled .Values.haproxy.stats.enabled $auth.enabled }}
+{{- if and (or $auth.username $auth.password) (not (and $auth.username $auth.password)) }}
+{{- fail "haproxy.stats.auth.username and haproxy.stats.auth.password must be set together." }}
+{{- end }}
+{{- if not (or $auth.username $auth.secretName) }}
+{{- fail "haproxy.stats.auth.enabled requires haproxy.stats.auth.username and haproxy.stats.auth.password, or the haproxy.stats.auth.secretName of an existing Secret." }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the Secret with the credentials of the HAProxy stats page, named like the HAProxy chart expects it
+*/}}
+{{- define "marklogic.haproxy.statsSecretName" -}}
+{{- default (printf "%s-haproxy-stats" .Release.Name) .Values.haproxy.stats.auth.secretName }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap the bootstrap host publishes the CA of the App Servers to, named like the HAProxy chart expects it
+*/}}
+{{- define "marklogic.haproxy.caConfigMapName" -}}
+{{- printf "%s-haproxy-ca" .Release.Name }}
+{{- end }}
+
+{{/*
+Validate the graceful drain of the hosts from HAProxy
+*/}}
+{{- define "marklogic.checkHAProxyDrain" -}}
+{{- if include "marklogic.haproxy.drain" . }}
+{{- $drain := .Values.haproxy.drain }}
+{{- if or (lt (int $drain.interval) 1) (lt (int $drain.timeout) 0) }}
+{{- fail "haproxy.drain.interval must be at least 1 and haproxy.drain.timeout at least 0 seconds." }}
+{{- end }}
+{{- $seconds := include "marklogic.haproxy.drainSeconds" . | int }}
+{{- if ge $seconds (int .Values.terminationGracePeriod) }}
+{{- fail (printf "The drain of a host from HAProxy takes %d seconds, two haproxy.drain.interval and haproxy.drain.timeout, which must be shorter than terminationGracePeriod %v to leave MarkLogic time to shut down." $seconds .Values.terminationGracePeriod) }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate how HAProxy finds the client IP
+*/}}
+{{- define "marklogic.checkHAProxyClientIP" -}}
+{{- if .Values.haproxy.enabled }}
+{{- $clientIP := .Values.haproxy.clientIP }}
+{{- if $clientIP.header }}
+{{- if not $clientIP.trustedProxies }}
+{{- fail "haproxy.clientIP.header requires haproxy.clientIP.trustedProxies, the CIDRs of the proxies allowed to set it." }}
+{{- end }}
+{{- range $clientIP.trustedProxies }}
+{{- if not (regexMatch "^[0-9a-fA-F:.]+(/[0-9]{1,3})?$" (toString .)) }}
+{{- fail (printf "The haproxy.clientIP.trustedProxies entry %v must be an IP address or a CIDR." .) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Values.haproxy.service.externalTrafficPolicy }}
+{{- if not (has . (list "Cluster" "Local")) }}
+{{- fail (printf "haproxy.service.externalTrafficPolicy is %q. It must be Cluster or Local." .) }}
+{{- end }}
+{{- if not (has $.Values.haproxy.service.type (list "LoadBalancer" "NodePort")) }}
+{{- fail "haproxy.service.externalTrafficPolicy requires haproxy.service.type LoadBalancer or NodePort." }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the custom XDQP certificate
+*/}}
+{{- define "marklogic.checkXdqpSsl" -}}
+{{- if and .Values.group.xdqpSsl.certSecretName (not .Values.group.enableXdqpSsl) }}
+{{- fail "group.xdqpSsl.certSecretName requires group.enableXdqpSsl to be true." }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate a release joining the cluster of bootstrapHostName is compatible with it. The StatefulSet of the
+bootstrap host is looked up when it runs in the same Kubernetes cluster, poststart-hook.sh checks the
+bootstrap host itself before joining.
+*/}}
+{{- define "marklogic.checkJoinCompatibility" -}}
+{{- $bootstrapHost := trim .Values.bootstrapHostName }}
+{{- if $bootstrapHost }}
+{{- if and (contains ".svc." $bootstrapHost) (not (hasSuffix (printf ".svc.%s" .Values.clusterDomain) $bootstrapHost)) }}
+{{- fail (printf "bootstrapHostName %s is not in the cluster domain %s of clusterDomain." $bootstrapHost .Values.clusterDomain) }}
+{{- end }}
+{{- $fqdn := include "marklogic.fqdn" . }}
+{{- $version := regexFind "^[0-9]+(\\.[0-9]+)+" (toString .Values.image.tag) }}
+{{- range (lookup "apps/v1" "StatefulSet" "" "").items }}
+{{- $annotations := .metadata.annotations | default dict }}
+{{- if and (eq (get $annotations "app.kubernetes.io/name") "marklogic") (ne (get $annotations "marklogic.com/fqdn") $fqdn) (eq (get $annotations "marklogic.com/fqdn") $bootstrapHost) }}
+{{- $release := .metadata.name }}
+{{- $tls := get $annotations "marklogic.com/tls-enabled" }}
+{{- if and $tls (ne $tls (toString $.Values.tls.enableOnDefaultAppServers)) }}
+{{- fail (printf "bootstrapHostName %s belongs to the release %s with tls.enableOnDefaultAppServers %s. It must be the same in this release to join its cluster." $bootstrapHost $release $tls) }}
+{{- end }}
+{{- if and (eq (get $annotations "marklogic.com/xdqp-custom-certificate") "true") (not $.Values.group.enableXdqpSsl) }}
+{{- fail (printf "bootstrapHostName %s belongs to the release %s with a custom XDQP certificate, which requires group.enableXdqpSsl to be true in this release." $bootstrapHost $release) }}
+{{- end }}
+{{- range .spec.template.spec.containers }}
+{{- if eq .name "marklogic-server" }}
+{{- $bootstrapVersion := regexFind "^[0-9]+(\\.[0-9]+)+" (regexReplaceAll "^.*:" .image "") }}
+{{- if and $version $bootstrapVersion (ne $version $bootstrapVersion) }}
+{{- fail (printf "bootstrapHostName %s belongs to the release %s running MarkLogic %s, this release runs MarkLogic %s. All hosts of a cluster must run the same MarkLogic version." $bootstrapHost $release $bootstrapVersion $version) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Minimum TLS version and ciphers of tls.policy as a JSON object, empty without policy
+*/}}
+{{- define "marklogic.tlsPolicy" -}}
+{{- $ecdhe := "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305" }}
+{{- $policy := .Values.tls.policy | default "" }}
+{{- if eq $policy "modern" }}
+{{- dict "minVersion" "TLSv1.3" "ciphers" $ecdhe | toJson }}
+{{- else if eq $policy "intermediate" }}
+{{- dict "minVersion" "TLSv1.2" "ciphers" (printf "%s:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305" $ecdhe) | toJson }}
+{{- else if eq $policy "custom" }}
+{{- pick .Values.tls.customPolicy "minVersion" "ciphers" | toJson }}
+{{- else }}
+{{- dict | toJson }}
+{{- end }}
+{{- end }}
+
+{{/*
+App Server properties applying tls.policy as a JSON object, empty without policy
+*/}}
+{{- define "marklogic.appServerTlsPolicy" -}}
+{{- $policy := include "marklogic.tlsPolicy" . | fromJson }}
+{{- if $policy }}
+{{- $versions := list "TLSv1.0" "TLSv1.1" "TLSv1.2" "TLSv1.3" }}
+{{- $min := 0 }}
+{{- range $i, $version := $versions }}
+{{- if eq $version $policy.minVersion }}
+{{- $min = $i }}
+{{- end }}
+{{- end }}
+{{- dict "ssl-disable-sslv3" true "ssl-disable-tlsv1" (gt $min 0) "ssl-disable-tlsv1-1" (gt $min 1) "ssl-disable-tlsv1-2" (gt $min 2) "ssl-ciphers" $policy.ciphers | toJson }}
+{{- else }}
+{{- dict | toJson }}
+{{- end }}
+{{- end }}
+
+{{/*
+Ports of the App Servers of tls.appServers that require client certificates, as a JSON list of strings
+*/}}
+{{- define "marklogic.clientAuthPorts" -}}
+{{- $ports := list }}
+{{- if .Values.tls.enableOnDefaultAppServers }}
+{{- range .Values.tls.appServers }}
+{{- if .clientCertRequired }}
+{{- $ports = append $ports (printf "%v" .port) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $ports }}
+{{- end }}
+
+{{/*
+Validate the roles and users
+*/}}
+{{- define "marklogic.checkSecurity" -}}
+{{- range $kind, $objects := dict "role" .Values.security.roles "user" .Values.security.users }}
+{{- $names := list }}
+{{- range $objects }}
+{{- if not (regexMatch "^[A-Za-z0-9_.@-]+$" (.name | default "" | toString)) }}
+{{- fail (printf "The %s name %q is invalid. It must consist of alphanumeric characters, '-', '_', '.' or '@'." $kind (.name | default "" | toString)) }}
+{{- end }}
+{{- if has .name $names }}
+{{- fail (printf "The %s name %q is used more than once." $kind .name) }}
+{{- end }}
+{{- $names = append $names .name }}
+{{- $name := .name }}
+{{- range .privileges }}
+{{- if or (not .name) (not .action) (not (has (.kind | default "execute") (list "execute" "uri"))) }}
+{{- fail (printf "The privileges of %s %q must set name, action and a kind of execute or uri." $kind $name) }}
+{{- end }}
+{{- end }}
+{{- range .permissions }}
+{{- if or (not .role) (not (has .capability (list "read" "update" "insert" "execute" "node-update"))) }}
+{{- fail (printf "The permissions of %s %q must set role and a capability of read, update, insert, execute or node-update." $kind $name) }}
+{{- end }}
+{{- end }}
+{{- if and (eq $kind "user") .passwordSecret (not .passwordSecret.name) }}
+{{- fail (printf "The passwordSecret of user %q must set name." .name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Whether any user of security.users takes its password from a secret
+*/}}
+{{- define "marklogic.userPasswordSecrets" -}}
+{{- range .Values.security.users }}
+{{- if .passwordSecret }}true{{ end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Manage API payload of a role or user from security.roles or security.users, without the password
+*/}}
+{{- define "marklogic.securityPayload" -}}
+{{- $payload := dict (printf "%s-name" .kind) .object.name "description" (.object.description | default "") "role" (.object.roles | default list) "collection" (.object.collections | default list) }}
+{{- $permissions := list }}
+{{- range .object.permissions }}
+{{- $permissions = append $permissions (dict "role-name" .role "capability" .capability) }}
+{{- end }}
+{{- $_ := set $payload "permission" $permissions }}
+{{- if eq .kind "role" }}
+{{- $privileges := list }}
+{{- range .object.privileges }}
+{{- $privileges = append $privileges (dict "privilege-name" .name "action" .action "kind" (.kind | default "execute")) }}
+{{- end }}
+{{- $_ := set $payload "privilege" $privileges }}
+{{- end }}
+{{- toJson $payload }}
+{{- end }}
+
+{{/*
+Validate the external security configuration
+*/}}
+{{- define "marklogic.checkExternalSecurity" -}}
+{{- $externalSecurity := .Values.externalSecurity }}
+{{- if $externalSecurity.enabled }}
+{{- if not (regexMatch "^[A-Za-z0-9_.-]+$" (toString $externalSecurity.name)) }}
+{{- fail (printf "externalSecurity.name %q is invalid. It must consist of alphanumeric characters, '-', '_' or '.'." (toString $externalSecurity.name)) }}
+{{- end }}
+{{- if not (regexMatch "^ldaps?://" (toString $externalSecurity.ldap.serverURI)) }}
+{{- fail "externalSecurity.ldap.serverURI must be an ldap:// or ldaps:// URI when externalSecurity is enabled." }}
+{{- end }}
+{{- if not $externalSecurity.ldap.base }}
+{{- fail "externalSecurity.ldap.base must be set when externalSecurity is enabled." }}
+{{- end }}
+{{- if not (has $externalSecurity.authorization (list "ldap" "internal")) }}
+{{- fail (printf "externalSecurity.authorization is %q. Supported values are ldap and internal." (toString $externalSecurity.authorization)) }}
+{{- end }}
+{{- range $externalSecurity.roleMapping }}
+{{- if or (not .role) (not .externalNames) }}
+{{- fail "Each of externalSecurity.roleMapping must set role and externalNames." }}
+{{- end }}
+{{- end }}
+{{- range $externalSecurity.appServers }}
+{{- if not .name }}
+{{- fail "Each of externalSecurity.appServers must set name." }}
+{{- end }}
+{{- if eq (toString .authentication) "digest" }}
+{{- fail (printf "The App Server %s can not use digest authentication with LDAP. Please use basic authentication." .name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Manage API payload of the external security configuration, without the bind credentials that are added from the secret
+*/}}
+{{- define "marklogic.externalSecurityPayload" -}}
+{{- $externalSecurity := .Values.externalSecurity }}
+{{- $ldap := dict "ldap-server-uri" $externalSecurity.ldap.serverURI "ldap-base" $externalSecurity.ldap.base "ldap-attribute" $externalSecurity.ldap.attribute "ldap-bind-method" $externalSecurity.ldap.bindMethod }}
+{{- with $externalSecurity.ldap.memberOfAttribute }}
+{{- $_ := set $ldap "ldap-memberof-attribute" . }}
+{{- end }}
+{{- with $externalSecurity.ldap.memberAttribute }}
+{{- $_ := set $ldap "ldap-member-attribute" . }}
+{{- end }}
+{{- $payload := dict "external-security-name" $externalSecurity.name "description" $externalSecurity.description "authentication" "ldap" "authorization" $externalSecurity.authorization "cache-timeout" $externalSecurity.cacheTimeout "ldap-server" $ldap }}
+{{- toJson $payload }}
+{{- end }}
+
+{{/*
+Validate the configuration bundle
+*/}}
+{{- define "marklogic.checkConfigBundle" -}}
+{{- if or (not .Values.configBundle.image.repository) (not .Values.configBundle.image.tag) }}
+{{- fail "configBundle.image.repository and configBundle.image.tag must be set to an image built with tools/mlconfig/Dockerfile when configBundle is enabled." }}
+{{- end }}
+{{- if and (not .Values.configBundle.volume) (not .Values.configBundle.configMaps) }}
+{{- fail "configBundle requires configMaps or a volume holding the bundle." }}
+{{- end }}
+{{- $paths := list }}
+{{- range .Values.configBundle.configMaps }}
+{{- if or (not .name) (not .path) }}
+{{- fail "Each of configBundle.configMaps must set name and path." }}
+{{- end }}
+{{- $path := trimAll "/" .path }}
+{{- if or (has $path $paths) (has ".." (splitList "/" $path)) }}
+{{- fail (printf "The configBundle.configMaps path %q is used more than once or leaves the bundle directory." .path) }}
+{{- end }}
+{{- $paths = append $paths $path }}
+{{- end }}
+{{- end }}
+
 {{/*
 Validate root to rootless upgrade
 */}}
@@ -240,6 +812,262 @@ Create the name of the Ingress to use.
 {{- printf "%s-ingress" (include "marklogic.fullname" .) }}
 {{- end }}
 
+{{/*
+Hosts HAProxy routes an App Server to as a JSON array of their StatefulSet, ordinal and FQDN.
+Takes the root context as root and the name of the App Server as appServer.
+Groups with an empty appServers serve every App Server. Every StatefulSet gets a host for each
+ordinal up to haproxy.maxReplicas, HAProxy routes to a host once the DNS name of its pod resolves.
+The hosts are not discovered with server-template and the SRV records of the headless service:
+the StatefulSets of all groups share that service, so its records mix the hosts of every group,
+and the servers of a template are named by slot, which gives neither the FQDN the certificate of
+a host is verified against nor a cookie that stays with the same host.
+*/}}
+{{- define "marklogic.haproxy.hosts" -}}
+{{- $headlessURL := include "marklogic.headlessURL" .root }}
+{{- $maxReplicas := int .root.Values.haproxy.maxReplicas }}
+{{- $hosts := list }}
+{{- range $group := include "marklogic.groups" .root | fromJsonArray }}
+{{- if or (not $group.appServers) (has $.appServer $group.appServers) }}
+{{- range $i := until (max (int $group.replicaCount) $maxReplicas | int) }}
+{{- $hosts = append $hosts (dict "statefulSet" $group.statefulSetName "ordinal" $i "fqdn" (printf "%s-%d.%s" $group.statefulSetName $i $headlessURL)) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- toJson $hosts }}
+{{- end }}
+
+{{/*
+Whether HAProxy verifies the certificates of the App Servers with TLS, "true" or empty.
+*/}}
+{{- define "marklogic.haproxy.verifyBackends" -}}
+{{- if and .Values.haproxy.enabled .Values.tls.enableOnDefaultAppServers .Values.haproxy.backendTls.verify }}
+{{- print "true" }}
+{{- end }}
+{{- end }}
+
+{{/*
+TLS options of an HAProxy server of an App Server with TLS.
+Takes the root context as root and the FQDN of the host as fqdn, which HAProxy sends as SNI and expects in the certificate.
+*/}}
+{{- define "marklogic.haproxy.serverSsl" -}}
+{{- if include "marklogic.haproxy.verifyBackends" .root -}}
+ssl verify required ca-file {{ .root.Values.haproxy.backendTls.caFile }} sni str({{ .fqdn }}) verifyhost {{ .fqdn }}
+{{- else -}}
+ssl verify none
+{{- end }}
+{{- end }}
+
+{{/*
+Session affinity of an HAProxy backend as JSON, the sessionAffinity of the App Server merged over haproxy.sessionAffinity.
+Takes the root context as root, the name of the App Server as name and its values as appServer.
+*/}}
+{{- define "marklogic.haproxy.sessionAffinitySettings" -}}
+{{- $affinity := mergeOverwrite (deepCopy .root.Values.haproxy.sessionAffinity) (default dict .appServer.sessionAffinity) }}
+{{- if not (has $affinity.mode (list "none" "cookie" "source" "session")) }}
+{{- fail (printf "The sessionAffinity.mode %v of the App Server %s must be one of none, cookie, source or session." $affinity.mode .name) }}
+{{- end }}
+{{- toJson $affinity }}
+{{- end }}
+
+{{/*
+Session affinity directives of an HAProxy backend, takes the settings of marklogic.haproxy.sessionAffinitySettings.
+The HostId and SessionId cookies of MarkLogic keep a session on the host that created it.
+*/}}
+{{- define "marklogic.haproxy.sessionAffinity" -}}
+{{- if eq .mode "cookie" -}}
+cookie haproxy insert indirect httponly nocache maxidle {{ .cookieMaxIdle }} maxlife {{ .cookieMaxLife }}
+{{ end }}
+{{- if has .mode (list "cookie" "session") -}}
+stick-table type string len 32 size 10k expire {{ .cookieMaxLife }}
+stick store-response res.cook(HostId)
+stick store-response res.cook(SessionId)
+stick match req.cook(HostId)
+stick match req.cook(SessionId)
+{{- else if eq .mode "source" -}}
+stick-table type ip size 100k expire {{ .cookieMaxIdle }}
+stick on src
+{{- end }}
+{{- end }}
+
+{{/*
+Client IP rules of an HAProxy backend or listen, the sourceRanges of the App Server merged over haproxy.sourceRanges.
+Takes the root context as root, the name of the App Server as name, its values as appServer and the proxy mode as
+mode. HTTP routes match the client IP of haproxy.clientIP and forward it to MarkLogic in X-Forwarded-For and
+X-Real-IP, TCP routes match the source address of the connection.
+*/}}
+{{- define "marklogic.haproxy.clientIP" -}}
+{{- $ranges := mergeOverwrite (deepCopy .root.Values.haproxy.sourceRanges) (default dict .appServer.sourceRanges) }}
+{{- $clientIP := .root.Values.haproxy.clientIP }}
+{{- $forward := or $clientIP.proxyProtocol $clientIP.header }}
+{{- range $list := list "allow" "deny" }}
+{{- range (index $ranges $list | default list) }}
+{{- if not (regexMatch "^[0-9a-fA-F:.]+(/[0-9]{1,3})?$" (toString .)) }}
+{{- fail (printf "The sourceRanges.%s entry %v of the App Server %s must be an IP address or a CIDR." $list . $.name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- $rules := list }}
+{{- if eq (default "http" .mode) "tcp" }}
+{{- with $ranges.deny }}
+{{- $rules = append $rules (printf "tcp-request content reject if { src %s }" (join " " .)) }}
+{{- end }}
+{{- with $ranges.allow }}
+{{- $rules = append $rules (printf "tcp-request content reject unless { src %s }" (join " " .)) }}
+{{- end }}
+{{- else if or $ranges.allow $ranges.deny $forward }}
+{{- $rules = append $rules "http-request set-var(txn.client_ip) src" }}
+{{- if $clientIP.header }}
+{{- $rules = append $rules (printf "http-request set-var(txn.client_ip) req.hdr_ip(%s,-1) if { src %s } { req.hdr(%s) -m found }" $clientIP.header (join " " $clientIP.trustedProxies) $clientIP.header) }}
+{{- end }}
+{{- with $ranges.deny }}
+{{- $rules = append $rules (printf "http-request deny deny_status 403 if { var(txn.client_ip) -m ip %s }" (join " " .)) }}
+{{- end }}
+{{- with $ranges.allow }}
+{{- $rules = append $rules (printf "http-request deny deny_status 403 unless { var(txn.client_ip) -m ip %s }" (join " " .)) }}
+{{- end }}
+{{- if $forward }}
+{{- $rules = append $rules "http-request set-header X-Forwarded-For %[var(txn.client_ip)]" }}
+{{- $rules = append $rules "http-request set-header X-Real-IP %[var(txn.client_ip)]" }}
+{{- end }}
+{{- end }}
+{{- join "\n" $rules }}
+{{- end }}
+
+{{/*
+Client limits of an HAProxy backend as JSON, the rateLimit of the App Server merged over haproxy.rateLimit.
+Takes the root context as root, the name of the App Server as name and its values as appServer.
+*/}}
+{{- define "marklogic.haproxy.rateLimitSettings" -}}
+{{- $limits := mergeOverwrite (deepCopy .root.Values.haproxy.rateLimit) (default dict .appServer.rateLimit) }}
+{{- range $key := list "maxConnections" "requestsPerSecond" "maxBodySize" }}
+{{- $value := index $limits $key | default 0 }}
+{{- if not (regexMatch "^[0-9]+$" (toString $value)) }}
+{{- fail (printf "The rateLimit.%s %v of the App Server %s must be a number of 0 or more." $key $value $.name) }}
+{{- end }}
+{{- $_ := set $limits $key (int64 $value) }}
+{{- end }}
+{{- toJson $limits }}
+{{- end }}
+
+{{/*
+Client limit rules of an HAProxy backend, takes the root context as root, the settings of
+marklogic.haproxy.rateLimitSettings as limits and the name of the backend as backend. Clients are tracked by source IP
+in the stick table of marklogic.haproxy.rateLimitTable, or by the client IP that marklogic.haproxy.clientIP reads from
+haproxy.clientIP.header, so that the clients behind a load balancer are not limited together.
+*/}}
+{{- define "marklogic.haproxy.rateLimit" -}}
+{{- $limits := .limits }}
+{{- $rules := list }}
+{{- if or $limits.maxConnections $limits.requestsPerSecond }}
+{{- $key := ternary "var(txn.client_ip)" "src" (not (empty .root.Values.haproxy.clientIP.header)) }}
+{{- $rules = append $rules (printf "http-request track-sc0 %s table %s-limits" $key .backend) }}
+{{- end }}
+{{- if $limits.maxConnections }}
+{{- $rules = append $rules (printf "http-request deny deny_status 429 if { sc0_conn_cur gt %d }" (int $limits.maxConnections)) }}
+{{- end }}
+{{- if $limits.requestsPerSecond }}
+{{- $rules = append $rules (printf "http-request deny deny_status 429 if { sc0_http_req_rate gt %d }" (int $limits.requestsPerSecond)) }}
+{{- end }}
+{{- if $limits.maxBodySize }}
+{{- /* a chunked body has no Content-Length to check, so it must declare one */}}
+{{- $rules = append $rules "http-request deny deny_status 411 if { req.hdr(transfer-encoding) -m found }" }}
+{{- $rules = append $rules (printf "http-request deny deny_status 413 if { req.hdr_val(content-length) gt %d }" (int64 $limits.maxBodySize)) }}
+{{- end }}
+{{- join "\n" $rules }}
+{{- end }}
+
+{{/*
+Stick table section counting the connections and requests of the clients of an HAProxy backend.
+Takes the settings of marklogic.haproxy.rateLimitSettings as limits and the name of the backend as backend.
+*/}}
+{{- define "marklogic.haproxy.rateLimitTable" -}}
+{{- if or .limits.maxConnections .limits.requestsPerSecond -}}
+backend {{ .backend }}-limits
+  stick-table type ip size {{ .limits.tableSize }} expire 10s store conn_cur,http_req_rate(1s)
+{{- end }}
+{{- end }}
+
+{{/*
+Settings of an HAProxy TCP port as JSON, its tls, healthCheck and timeout with their defaults.
+Takes the root context as root and the entry of haproxy.tcpports.ports as port.
+*/}}
+{{- define "marklogic.haproxy.tcpPortSettings" -}}
+{{- $port := .port }}
+{{- $tls := mergeOverwrite (dict "mode" "" "sni" list) (default dict $port.tls) }}
+{{- $check := mergeOverwrite (dict "type" "http" "user" "healthcheck") (default dict $port.healthCheck) }}
+{{- $timeout := default dict $port.timeout }}
+{{- if not (has $tls.mode (list "" "terminate" "passthrough")) }}
+{{- fail (printf "The tls.mode %v of the TCP port %s must be terminate or passthrough." $tls.mode $port.name) }}
+{{- end }}
+{{- if and (eq $tls.mode "terminate") (not .root.Values.haproxy.tls.enabled) }}
+{{- fail (printf "The tls.mode terminate of the TCP port %s requires haproxy.tls.enabled for the certificate of HAProxy." $port.name) }}
+{{- end }}
+{{- if and $tls.sni (ne $tls.mode "passthrough") }}
+{{- fail (printf "The tls.sni of the TCP port %s requires tls.mode passthrough." $port.name) }}
+{{- end }}
+{{- if not (has $check.type (list "http" "pgsql")) }}
+{{- fail (printf "The healthCheck.type %v of the TCP port %s must be http or pgsql." $check.type $port.name) }}
+{{- end }}
+{{- range $name, $value := $timeout }}
+{{- if not (has $name (list "client" "server" "connect")) }}
+{{- fail (printf "The timeout.%s of the TCP port %s must be one of client, server or connect." $name $port.name) }}
+{{- end }}
+{{- if not (regexMatch "^[0-9]+(us|ms|s|m|h|d)?$" (toString $value)) }}
+{{- fail (printf "The timeout.%s %v of the TCP port %s must be a number with an optional unit, such as 30s or 1h." $name $value $port.name) }}
+{{- end }}
+{{- end }}
+{{- toJson (dict "tls" $tls "healthCheck" $check "timeout" $timeout) }}
+{{- end }}
+
+{{/*
+Health check and drain directives of an HAProxy backend.
+Takes the root context as root and the healthCheckPath of the App Server as path.
+Without a path, HAProxy checks the HealthCheck App Server of the host.
+With a pgsqlUser, HAProxy checks the port of a TCP App Server with a PostgreSQL startup message of the user.
+*/}}
+{{- define "marklogic.haproxy.healthCheck" -}}
+{{- $check := .root.Values.haproxy.healthCheck }}
+{{- $agent := "" }}
+{{- if include "marklogic.haproxy.drain" .root }}
+{{- $agent = printf " agent-check agent-port %v agent-inter %vs" .root.Values.haproxy.drain.port .root.Values.haproxy.drain.interval }}
+{{- end }}
+{{- if .pgsqlUser -}}
+option pgsql-check user {{ .pgsqlUser }}
+option redispatch
+retries 3
+retry-on conn-failure
+default-server check inter {{ $check.interval }} rise {{ $check.rise }} fall {{ $check.fall }}{{ $agent }}
+{{- else if $check.enabled -}}
+option httpchk
+http-check send meth GET uri {{ default "/" .path }}
+http-check expect status 200
+option redispatch
+retries 3
+retry-on conn-failure
+default-server check{{ if not .path }} port {{ $check.port }}{{ end }} inter {{ $check.interval }} rise {{ $check.rise }} fall {{ $check.fall }}{{ $agent }}
+{{- else -}}
+default-server check{{ $agent }}
+{{- end }}
+{{- end }}
+
+{{/*
+Whether the hosts are drained from HAProxy before they shut down, "true" or empty.
+*/}}
+{{- define "marklogic.haproxy.drain" -}}
+{{- if and .Values.haproxy.enabled .Values.haproxy.drain.enabled }}
+{{- print "true" }}
+{{- end }}
+{{- end }}
+
+{{/*
+Seconds the preStop hook waits for HAProxy to drain the host and for the requests in progress to finish:
+the agent check of the next interval may just have started, so two intervals and the timeout.
+*/}}
+{{- define "marklogic.haproxy.drainSeconds" -}}
+{{- $drain := .Values.haproxy.drain }}
+{{- add (mul 2 (int $drain.interval)) (int $drain.timeout) }}
+{{- end }}
+
 {{/*
 Name of the HAProxy Service name to use in Ingress.
 */}}
@@ -0,0 +1,172 @@
+package e2e
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyProtocolRequest sends a request to address after a PROXY protocol v1 header naming clientIP as the source,
+// like a network load balancer in front of HAProxy
+func proxyProtocolRequest(address string, clientIP string, request *http.Request) (int, string, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return 0, "", err
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 51000 %s\r\n", clientIP, port); err != nil {
+		return 0, "", err
+	}
+	if err := request.Write(conn); err != nil {
+		return 0, "", err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// TestHAProxyProxyProtocol checks what docs/Local_Development_Tutorial.md shows with curl --haproxy-protocol:
+// HAProxy takes the client IP from the PROXY header, matches it against the source ranges of the App Servers
+// and forwards it to MarkLogic.
+func TestHAProxyProxyProtocol(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	username := "admin"
+	password := "admin"
+
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+
+	if !repoPres {
+		imageRepo = "ml-docker-db-dev-tierpoint.bed-artifactory.bedford.progress.com/marklogic/marklogic-server-centos"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "11.0.nightly-centos-1.0.2"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":            "false",
+			"replicaCount":                   "1",
+			"image.repository":               imageRepo,
+			"image.tag":                      imageTag,
+			"auth.adminUsername":             username,
+			"auth.adminPassword":             password,
+			"logCollection.enabled":          "false",
+			"haproxy.enabled":                "true",
+			"haproxy.replicaCount":           "1",
+			"haproxy.frontendPort":           "80",
+			"haproxy.pathbased.enabled":      "true",
+			"haproxy.clientIP.proxyProtocol": "true",
+			"haproxy.defaultAppServers.manage.sourceRanges.allow": "{203.0.113.0/24}",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-client-ip"
+	helm.Install(t, options, helmChartPath, releaseName)
+
+	podName := releaseName + "-0"
+	svcName := releaseName + "-haproxy"
+
+	// wait until the pod is in Ready status
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podName, 15, 20*time.Second)
+
+	tunnel := k8s.NewTunnel(
+		kubectlOptions, k8s.ResourceTypeService, svcName, 8080, 80)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+	address := tunnel.Endpoint()
+
+	newRequest := func(method string, path string, body string) *http.Request {
+		request, err := http.NewRequest(method, "http://"+address+path, strings.NewReader(body))
+		require.NoError(t, err)
+		request.SetBasicAuth(username, password)
+		if body != "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		// a client may not choose the address MarkLogic sees
+		request.Header.Set("X-Forwarded-For", "192.0.2.1")
+		return request
+	}
+
+	// Verify the manage App Server answers clients of the allowed range, waiting for MarkLogic to be ready
+	t.Log("====Verifying a client of the allowed range reaches the manage App Server")
+	allowed := 0
+	for i := 0; i < 15 && allowed != 200; i++ {
+		status, _, err := proxyProtocolRequest(address, "203.0.113.7", newRequest("GET", "/manage/manage/v2?format=json", ""))
+		if err != nil {
+			t.Logf("error: %s", err.Error())
+		} else {
+			allowed = status
+			t.Logf("StatusCode: %d", status)
+		}
+		if allowed != 200 {
+			t.Log("Waiting for MarkLogic cluster to be ready")
+			time.Sleep(15 * time.Second)
+		}
+	}
+	require.Equal(t, 200, allowed)
+
+	// Verify the source range is matched against the address of the PROXY header, not of the port-forward
+	t.Log("====Verifying a client outside the allowed range is denied")
+	status, _, err := proxyProtocolRequest(address, "198.51.100.7", newRequest("GET", "/manage/manage/v2?format=json", ""))
+	require.NoError(t, err)
+	require.Equal(t, 403, status)
+
+	// Verify MarkLogic receives the client IP instead of the address of HAProxy or the one sent by the client
+	t.Log("====Verifying MarkLogic receives the client IP")
+	form := url.Values{"xquery": {`fn:string-join((xdmp:get-request-header("X-Real-IP"), xdmp:get-request-header("X-Forwarded-For")), ",")`}}
+	status, body, err := proxyProtocolRequest(address, "198.51.100.7", newRequest("POST", "/console/v1/eval", form.Encode()))
+	require.NoError(t, err)
+	require.Equal(t, 200, status, body)
+	require.Contains(t, body, "198.51.100.7,198.51.100.7")
+	require.NotContains(t, body, "192.0.2.1")
+
+	// Verify HAProxy refuses connections without the PROXY header
+	t.Log("====Verifying a connection without the PROXY header is refused")
+	resp, err := http.DefaultClient.Do(newRequest("GET", "/console/", ""))
+	if err == nil {
+		defer resp.Body.Close()
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+}
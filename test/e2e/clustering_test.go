@@ -111,13 +111,11 @@ func TestClusterJoin(t *testing.T) {
 		testUtil.HelmUpgrade(t, helmUpgradeOptions, releaseName, kubectlOptions, []string{podZeroName, podOneName}, initialChartVersion)
 	}
 
-	output, err := testUtil.WaitUntilPodRunning(t, kubectlOptions, podZeroName, 10, 15*time.Second)
+	// wait until the bootstrap host has completed its bootstrap
+	_, err := testUtil.WaitUntilBootstrapCompleted(t, kubectlOptions, podZeroName, 10, 15*time.Second)
 	if err != nil {
 		t.Error(err.Error())
 	}
-	if output != "Running" {
-		t.Error(output)
-	}
 
 	tunnel := k8s.NewTunnel(
 		kubectlOptions, k8s.ResourceTypePod, podZeroName, 8002, 8002)
@@ -163,13 +161,11 @@ func TestClusterJoin(t *testing.T) {
 		t.Errorf("Wrong number of hosts")
 	}
 
-	output, err = testUtil.WaitUntilPodRunning(t, kubectlOptions, podOneName, 10, 15*time.Second)
+	// verify the second host reports that it joined the cluster
+	_, err = testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, podOneName, "join", 10, 15*time.Second)
 	if err != nil {
 		t.Error(err.Error())
 	}
-	if output != "Running" {
-		t.Error(output)
-	}
 
 	tlsConfig := tls.Config{}
 	// restart all pods in the cluster and verify its ready and MarkLogic server is healthy
@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/imroc/req/v3"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+)
+
+// openLDAP runs an OpenLDAP server with the user ldapuser in the group cn=readers,ou=users,dc=example,dc=org
+const openLDAP = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: openldap
+  labels:
+    app: openldap
+spec:
+  containers:
+    - name: openldap
+      image: bitnami/openldap:2.6
+      env:
+        - name: LDAP_ROOT
+          value: dc=example,dc=org
+        - name: LDAP_ADMIN_USERNAME
+          value: admin
+        - name: LDAP_ADMIN_PASSWORD
+          value: adminpassword
+        - name: LDAP_USERS
+          value: ldapuser
+        - name: LDAP_PASSWORDS
+          value: ldappassword
+        - name: LDAP_GROUP
+          value: readers
+      ports:
+        - containerPort: 1389
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: openldap
+spec:
+  selector:
+    app: openldap
+  ports:
+    - port: 1389
+      targetPort: 1389
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: ldap-bind
+stringData:
+  bind-dn: cn=admin,dc=example,dc=org
+  password: adminpassword
+`
+
+func TestExternalSecurityLDAP(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest-11"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":                              "false",
+			"replicaCount":                                     "1",
+			"image.repository":                                 imageRepo,
+			"image.tag":                                        imageTag,
+			"auth.adminUsername":                               "admin",
+			"auth.adminPassword":                               "admin",
+			"logCollection.enabled":                            "false",
+			"externalSecurity.enabled":                         "true",
+			"externalSecurity.name":                            "openldap",
+			"externalSecurity.ldap.serverURI":                  "ldap://openldap:1389",
+			"externalSecurity.ldap.base":                       "ou=users\\,dc=example\\,dc=org",
+			"externalSecurity.ldap.attribute":                  "cn",
+			"externalSecurity.ldap.memberAttribute":            "member",
+			"externalSecurity.ldap.bindSecretName":             "ldap-bind",
+			"externalSecurity.roleMapping[0].role":             "rest-reader",
+			"externalSecurity.roleMapping[0].externalNames[0]": "cn=readers\\,ou=users\\,dc=example\\,dc=org",
+			"externalSecurity.appServers[0].name":              "App-Services",
+			"externalSecurity.appServers[0].internalSecurity":  "true",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	t.Logf("====Starting OpenLDAP")
+	k8s.KubectlApplyFromString(t, kubectlOptions, openLDAP)
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, "openldap", 20, 5*time.Second)
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-ldap"
+	podName := testUtil.HelmInstall(t, options, releaseName, kubectlOptions, helmChartPath)
+
+	// the external security configuration is created once the bootstrap host is bootstrapped
+	_, err := testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, podName, "external-security", 20, 15*time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, podName, 8000, 8000)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+	endpoint := "http://" + tunnel.Endpoint() + "/v1/search?format=json"
+
+	// the LDAP user gets rest-reader from its LDAP group
+	resp, err := req.C().R().SetBasicAuth("ldapuser", "ldappassword").Get(endpoint)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp.GetStatusCode() != http.StatusOK {
+		t.Errorf("LDAP user was not authenticated on App-Services, response code: %d", resp.GetStatusCode())
+	}
+
+	// a wrong password is rejected by LDAP
+	resp, err = req.C().R().SetBasicAuth("ldapuser", "wrong").Get(endpoint)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp.GetStatusCode() != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong LDAP password to be rejected, response code: %d", resp.GetStatusCode())
+	}
+
+	// internal security still authenticates the admin user
+	resp, err = req.C().R().SetBasicAuth("admin", "admin").Get(endpoint)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp.GetStatusCode() != http.StatusOK {
+		t.Errorf("Admin user was not authenticated on App-Services, response code: %d", resp.GetStatusCode())
+	}
+}
@@ -0,0 +1,186 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/imroc/req/v3"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestHAProxyHotReload keeps a long request open through HAProxy across a helm upgrade changing its configuration,
+// HAProxy reloads the configuration without restarting its pods and the request completes.
+func TestHAProxyHotReload(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	username := "admin"
+	password := "admin"
+
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+
+	if !repoPres {
+		imageRepo = "ml-docker-db-dev-tierpoint.bed-artifactory.bedford.progress.com/marklogic/marklogic-server-centos"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "11.0.nightly-centos-1.0.2"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":        "false",
+			"replicaCount":               "1",
+			"image.repository":           imageRepo,
+			"image.tag":                  imageTag,
+			"auth.adminUsername":         username,
+			"auth.adminPassword":         password,
+			"logCollection.enabled":      "false",
+			"haproxy.enabled":            "true",
+			"haproxy.replicaCount":       "1",
+			"haproxy.frontendPort":       "80",
+			"haproxy.pathbased.enabled":  "true",
+			"haproxy.hotReload.enabled":  "true",
+			"haproxy.hotReload.interval": "2",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-reload"
+	helm.Install(t, options, helmChartPath, releaseName)
+
+	podName := releaseName + "-0"
+	svcName := releaseName + "-haproxy"
+
+	// wait until the pod is in Ready status
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podName, 15, 20*time.Second)
+
+	tunnel := k8s.NewTunnel(
+		kubectlOptions, k8s.ResourceTypeService, svcName, 8080, 80)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+
+	client := req.C().
+		SetCommonBasicAuth(username, password).
+		SetCommonRetryCount(15).
+		SetCommonRetryFixedInterval(15 * time.Second)
+
+	manageEndpoint := "http://localhost:8080/manage/manage/v2?format=json"
+	t.Log("====Waiting for MarkLogic behind HAProxy")
+	resp, err := client.R().
+		AddRetryCondition(func(resp *req.Response, err error) bool {
+			if err != nil {
+				t.Logf("error: %s", err.Error())
+				return true
+			}
+			if resp.GetStatusCode() != 200 {
+				t.Logf("Server with response code: %d", resp.GetStatusCode())
+				t.Log("Waiting for MarkLogic cluster to be ready")
+			}
+			return resp.GetStatusCode() != 200
+		}).
+		Get(manageEndpoint)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.GetStatusCode())
+
+	haproxyPods := func() map[string]int32 {
+		pods := k8s.ListPods(t, kubectlOptions, metav1.ListOptions{LabelSelector: "app.kubernetes.io/name=haproxy,app.kubernetes.io/instance=" + releaseName})
+		restarts := map[string]int32{}
+		for _, pod := range pods {
+			for _, status := range pod.Status.ContainerStatuses {
+				restarts[pod.Name] += status.RestartCount
+			}
+		}
+		return restarts
+	}
+	haproxyPodsBefore := haproxyPods()
+	require.Len(t, haproxyPodsBefore, 1)
+
+	// open a request MarkLogic answers after the upgrade, on a connection served by the current HAProxy worker
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	longRequest := make(chan result, 1)
+	go func() {
+		resp, err := req.C().
+			SetTimeout(10*time.Minute).
+			SetCommonBasicAuth(username, password).
+			R().
+			SetFormData(map[string]string{"xquery": `xdmp:sleep(240000), "survived the reload"`}).
+			Post("http://localhost:8080/console/v1/eval")
+		if err != nil {
+			longRequest <- result{err: err}
+			return
+		}
+		longRequest <- result{status: resp.GetStatusCode(), body: resp.String()}
+	}()
+	time.Sleep(5 * time.Second)
+
+	t.Log("====Upgrading the release with a new HAProxy configuration")
+	options.SetValues["haproxy.defaultAppServers.manage.rateLimit.requestsPerSecond"] = "1000"
+	helm.Upgrade(t, options, helmChartPath, releaseName)
+
+	var haproxyPod string
+	for pod := range haproxyPodsBefore {
+		haproxyPod = pod
+	}
+
+	// wait for the kubelet to update the ConfigMap in the pod and for the sidecar to reload HAProxy
+	reloaded := false
+	for i := 0; i < 36 && !reloaded; i++ {
+		time.Sleep(5 * time.Second)
+		config, err := k8s.RunKubectlAndGetOutputE(t, kubectlOptions, "exec", haproxyPod, "-c", "haproxy", "--", "cat", "/usr/local/etc/haproxy/haproxy.cfg")
+		if err != nil || !strings.Contains(config, "sc0_http_req_rate gt 1000") {
+			t.Log("Waiting for the kubelet to update the HAProxy configuration")
+			continue
+		}
+		logs, err := k8s.GetPodLogsE(t, kubectlOptions, k8s.GetPod(t, kubectlOptions, haproxyPod), "config-reloader")
+		reloaded = err == nil && strings.Contains(logs, "reloading HAProxy")
+	}
+	require.True(t, reloaded, "HAProxy was not reloaded")
+
+	// Verify the long request was still open when HAProxy reloaded, so it spans the reload
+	select {
+	case r := <-longRequest:
+		t.Fatalf("The long request completed before the reload: %d %s %v", r.status, r.body, r.err)
+	default:
+	}
+
+	// Verify the new workers serve new requests with the new configuration
+	resp, err = client.R().Get(manageEndpoint)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.GetStatusCode())
+
+	t.Log("====Verifying the long request survived the reload")
+	r := <-longRequest
+	require.NoError(t, r.err)
+	require.Equal(t, 200, r.status, r.body)
+	require.Contains(t, r.body, "survived the reload")
+
+	// Verify HAProxy was neither rolled nor restarted
+	require.Equal(t, haproxyPodsBefore, haproxyPods(), fmt.Sprintf("HAProxy pods before the upgrade: %v", haproxyPodsBefore))
+}
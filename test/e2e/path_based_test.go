@@ -2,6 +2,7 @@ package e2e
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -323,6 +324,7 @@ func TestPathBasedRoutingWithTLS(t *testing.T) {
 			"haproxy.replicaCount":          "1",
 			"haproxy.frontendPort":          "80",
 			"haproxy.pathbased.enabled":     "true",
+			"haproxy.backendTls.verify":     "true",
 		},
 	}
 
@@ -414,7 +416,54 @@ func TestPathBasedRoutingWithTLS(t *testing.T) {
 		}
 	}
 
+	// verify HAProxy rejects the App Servers when their certificates are not issued by the CA it trusts
+	publishedCA := k8s.GetConfigMap(t, kubectlOptions, releaseName+"-haproxy-ca").Data["ca.pem"]
+	if publishedCA == "" {
+		t.Fatalf("the bootstrap host did not publish the CA of the App Servers")
+	}
+	otherCA := testUtil.NewCertificateAuthority(t, "other-ca")
+	untrustedTunnel := restartHAProxyWithCA(t, kubectlOptions, svcName, 8081, string(otherCA.CertPEM))
+	defer untrustedTunnel.Close()
+	resp, err := client.R().
+		AddRetryCondition(func(resp *req.Response, err error) bool {
+			return err != nil || resp.GetStatusCode() != 503
+		}).
+		Get("http://localhost:8081/manage/dashboard")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	t.Logf("Response code with a backend certificate HAProxy does not trust: %d", resp.GetStatusCode())
+	assert.Equal(t, 503, resp.GetStatusCode())
+
+	// verify HAProxy routes to the App Servers again once it trusts their CA
+	trustedTunnel := restartHAProxyWithCA(t, kubectlOptions, svcName, 8082, publishedCA)
+	defer trustedTunnel.Close()
+	resp, err = client.R().
+		AddRetryCondition(func(resp *req.Response, err error) bool {
+			return err != nil || resp.GetStatusCode() != 200
+		}).
+		Get("http://localhost:8082/manage/dashboard")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	assert.Equal(t, 200, resp.GetStatusCode())
+
 	tlsConfig := tls.Config{}
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
 	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName, podTwoName}, namespaceName, kubectlOptions, &tlsConfig)
 }
+
+// restartHAProxyWithCA replaces the CA HAProxy verifies the App Servers with, restarts HAProxy to load it
+// and returns a tunnel from localPort to the new HAProxy pod. The CA is in the ConfigMap <svcName>-ca, <release>-haproxy-ca.
+func restartHAProxyWithCA(t *testing.T, kubectlOptions *k8s.KubectlOptions, svcName string, localPort int, ca string) *k8s.Tunnel {
+	patch, err := json.Marshal(map[string]map[string]string{"data": {"ca.pem": ca}})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	k8s.RunKubectl(t, kubectlOptions, "patch", "configmap", svcName+"-ca", "--type", "merge", "-p", string(patch))
+	k8s.RunKubectl(t, kubectlOptions, "rollout", "restart", "deployment", svcName)
+	k8s.RunKubectl(t, kubectlOptions, "rollout", "status", "deployment", svcName, "--timeout=300s")
+	tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypeService, svcName, localPort, 80)
+	tunnel.ForwardPort(t)
+	return tunnel
+}
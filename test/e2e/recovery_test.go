@@ -0,0 +1,151 @@
+package e2e
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/imroc/req/v3"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/tidwall/gjson"
+)
+
+// deletePodVolume deletes the data volume of a pod together with the pod, as if its node was lost
+func deletePodVolume(t *testing.T, kubectlOptions *k8s.KubectlOptions, podName string) {
+	t.Logf("====Deleting the data volume of %s", podName)
+	k8s.RunKubectl(t, kubectlOptions, "delete", "pvc", "datadir-"+podName, "--wait=false")
+	k8s.RunKubectl(t, kubectlOptions, "delete", "pod", podName)
+}
+
+func TestLostBootstrapHostVolume(t *testing.T) {
+	lostBootstrapHostVolume(t, false)
+}
+
+func TestLostBootstrapHostVolumeWithTLS(t *testing.T) {
+	lostBootstrapHostVolume(t, true)
+}
+
+// lostBootstrapHostVolume deletes the data volumes of the bootstrap hosts of two releases,
+// with the default App Servers on HTTPS when tlsEnabled is set
+func lostBootstrapHostVolume(t *testing.T, tlsEnabled bool) {
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest-11"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	dnodeReleaseName := "dnode"
+	enodeReleaseName := "enode"
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	protocol := "http"
+	if tlsEnabled {
+		protocol = "https"
+	}
+	values := func(group string, replicas string) map[string]string {
+		return map[string]string{
+			"persistence.enabled":           "true",
+			"replicaCount":                  replicas,
+			"image.repository":              imageRepo,
+			"image.tag":                     imageTag,
+			"auth.adminUsername":            username,
+			"auth.adminPassword":            password,
+			"group.name":                    group,
+			"logCollection.enabled":         "false",
+			"tls.enableOnDefaultAppServers": fmt.Sprint(tlsEnabled),
+		}
+	}
+
+	t.Logf("====Installing Helm Chart " + dnodeReleaseName)
+	dnodeOptions := &helm.Options{KubectlOptions: kubectlOptions, SetValues: values("dnode", "2")}
+	dnodePodName := testUtil.HelmInstall(t, dnodeOptions, dnodeReleaseName, kubectlOptions, helmChartPath)
+	bootstrapHost, err := VerifyDnodeConfig(t, dnodePodName, kubectlOptions, protocol)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	t.Logf("====Installing Helm Chart " + enodeReleaseName)
+	enodeValues := values("enode", "2")
+	enodeValues["bootstrapHostName"] = bootstrapHost
+	enodeOptions := &helm.Options{KubectlOptions: kubectlOptions, SetValues: enodeValues}
+	enodePodName := testUtil.HelmInstall(t, enodeOptions, enodeReleaseName, kubectlOptions, helmChartPath)
+	enodePods := []string{enodePodName, enodeReleaseName + "-1"}
+	for _, pod := range enodePods {
+		k8s.WaitUntilPodAvailable(t, kubectlOptions, pod, 45, 20*time.Second)
+		if _, err = testUtil.WaitUntilBootstrapCompleted(t, kubectlOptions, pod, 30, 10*time.Second); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	hostCount := func() int64 {
+		tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, dnodeReleaseName+"-1", 0, 8002)
+		defer tunnel.Close()
+		tunnel.ForwardPort(t)
+		resp, err := req.C().EnableInsecureSkipVerify().
+			SetCommonDigestAuth(username, password).
+			SetCommonRetryCount(10).
+			SetCommonRetryFixedInterval(10 * time.Second).
+			R().Get(fmt.Sprintf("%s://%s/manage/v2/hosts?format=json", protocol, tunnel.Endpoint()))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return gjson.Get(resp.String(), `host-default-list.list-items.list-count.value`).Int()
+	}
+	if hosts := hostCount(); hosts != 4 {
+		t.Fatalf("Expected 4 hosts in the cluster, got %d", hosts)
+	}
+
+	// pod-0 of the release comes back with an empty volume and joins the cluster again
+	deletePodVolume(t, kubectlOptions, enodePodName)
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, enodePodName, 45, 20*time.Second)
+	status, err := testUtil.WaitUntilBootstrapCompleted(t, kubectlOptions, enodePodName, 30, 10*time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if status.Phases["recovery"] != "Succeeded" {
+		t.Errorf("Expected the recovery phase of %s to succeed, got %s", enodePodName, status.Phases["recovery"])
+	}
+	if hosts := hostCount(); hosts != 4 {
+		t.Errorf("Expected 4 hosts in the cluster after the recovery, got %d", hosts)
+	}
+
+	// every pod of the release restarts with its configuration
+	tlsConfig := tls.Config{InsecureSkipVerify: tlsEnabled}
+	testUtil.RestartPodAndVerify(t, false, enodePods, namespaceName, kubectlOptions, &tlsConfig)
+
+	// the bootstrap host of the cluster holds the system forests, it does not create a new cluster without them
+	deletePodVolume(t, kubectlOptions, dnodePodName)
+	_, err = testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, dnodePodName, "recovery", 45, 20*time.Second)
+	if err == nil {
+		t.Fatalf("Expected the recovery of %s to fail", dnodePodName)
+	}
+	if !strings.Contains(err.Error(), "do not answer for their cluster") && !strings.Contains(err.Error(), "are assigned to it") {
+		t.Errorf("Unexpected recovery error: %v", err)
+	}
+}
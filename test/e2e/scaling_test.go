@@ -2,6 +2,7 @@ package e2e
 
 import (
 	"crypto/tls"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"github.com/imroc/req/v3"
 	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
 	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestHelmScaleUp(t *testing.T) {
@@ -190,3 +192,100 @@ func TestHelmScaleUp(t *testing.T) {
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
 	testUtil.RestartPodAndVerify(t, true, []string{podZeroName, podOneName}, namespaceName, kubectlOptions, &tlsConfig)
 }
+
+func TestHAProxyFollowsStatefulSetScale(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+	username := "admin"
+	password := "admin"
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest-11"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":   "true",
+			"replicaCount":          "1",
+			"image.repository":      imageRepo,
+			"image.tag":             imageTag,
+			"auth.adminUsername":    username,
+			"auth.adminPassword":    password,
+			"logCollection.enabled": "false",
+			"haproxy.enabled":       "true",
+			"haproxy.replicaCount":  "1",
+			"haproxy.maxReplicas":   "3",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	releaseName := "test-scale-haproxy"
+	t.Logf("====Installing Helm Chart")
+	podZeroName := testUtil.HelmInstall(t, options, releaseName, kubectlOptions, helmChartPath)
+	podOneName := releaseName + "-1"
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podZeroName, 30, 10*time.Second)
+
+	haproxyPods := func() []string {
+		pods := k8s.ListPods(t, kubectlOptions, metav1.ListOptions{LabelSelector: "app.kubernetes.io/name=haproxy"})
+		names := []string{}
+		for _, pod := range pods {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+	haproxyPodsBefore := haproxyPods()
+
+	// scale the StatefulSet without helm, HAProxy is not restarted and its configuration is not rendered again
+	t.Logf("====Scaling up pods using kubectl scale")
+	k8s.RunKubectl(t, kubectlOptions, "scale", "statefulset", releaseName, "--replicas=2")
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podOneName, 30, 10*time.Second)
+	if _, err := testUtil.WaitUntilBootstrapCompleted(t, kubectlOptions, podOneName, 30, 10*time.Second); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypeService, releaseName+"-haproxy", 0, 8002)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+
+	// HAProxy names the server of every response in its cookie, requests without the cookie are balanced over the hosts
+	newServer := releaseName + "-manage-1"
+	servers := map[string]bool{}
+	client := req.C().SetCommonDigestAuth(username, password)
+	for i := 0; i < 30 && !servers[newServer]; i++ {
+		resp, err := client.R().Get(fmt.Sprintf("http://%s/manage/v2/hosts?format=json", tunnel.Endpoint()))
+		if err != nil {
+			t.Logf("error: %s", err.Error())
+		} else {
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == "haproxy" {
+					servers[cookie.Value] = true
+				}
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+	if !servers[newServer] {
+		t.Errorf("HAProxy did not route to the new host %s, routed to %v", podOneName, servers)
+	}
+	if haproxyPodsAfter := haproxyPods(); strings.Join(haproxyPodsAfter, ",") != strings.Join(haproxyPodsBefore, ",") {
+		t.Errorf("HAProxy pods changed while scaling: %v, %v", haproxyPodsBefore, haproxyPodsAfter)
+	}
+}
@@ -452,6 +452,12 @@ func TestIncorrectBootsrapHostname(t *testing.T) {
 	// Give pod time to fail before checking if it did
 	time.Sleep(20 * time.Second)
 
+	// Verify the compatibility check reports the unreachable bootstrap host
+	_, err = testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, enodeReleaseName+"-0", "compatibility", 30, 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "can not be reached, check bootstrapHostName") {
+		t.Errorf("Expected the compatibility check to fail, got: %v", err)
+	}
+
 	totalHostsJSON := gjson.Get(string(body), "host-default-list.list-items.list-count.value")
 
 	// Total hosts be one as second host should have failed to create
@@ -493,4 +499,56 @@ func TestIncorrectBootsrapHostname(t *testing.T) {
 	// restart pods in the cluster and verify its ready and MarkLogic server is healthy
 	testUtil.RestartPodAndVerify(t, false, []string{dnodePodName}, namespaceName, kubectlOptions, &tlsConfig)
 
+	helm.Delete(t, enodeOptions, enodeReleaseName, true)
+	k8s.RunKubectl(t, kubectlOptions, "delete", "pvc", "--selector", "app.kubernetes.io/instance="+enodeReleaseName)
+	bootstrapHost := fmt.Sprintf("%s-0.%s.%s.svc.cluster.local", dnodeReleaseName, dnodeReleaseName, namespaceName)
+
+	// Verify incompatible releases are refused by the chart before any pod is created
+	type templateFailure struct {
+		values        map[string]string
+		expectedError string
+	}
+	templateFailures := map[string]templateFailure{
+		"tls": {
+			values:        map[string]string{"tls.enableOnDefaultAppServers": "true"},
+			expectedError: "with tls.enableOnDefaultAppServers false. It must be the same in this release",
+		},
+		"cluster domain": {
+			values:        map[string]string{"bootstrapHostName": fmt.Sprintf("%s-0.%s.%s.svc.example.org", dnodeReleaseName, dnodeReleaseName, namespaceName)},
+			expectedError: "is not in the cluster domain cluster.local of clusterDomain",
+		},
+	}
+	// the version can only be compared with a numbered image tag
+	if version := strings.SplitN(imageTag, "-", 2)[0]; strings.Count(version, ".") > 0 {
+		templateFailures["version"] = templateFailure{
+			values:        map[string]string{"image.tag": "10.0-ubi"},
+			expectedError: "All hosts of a cluster must run the same MarkLogic version",
+		}
+	}
+	for name, tc := range templateFailures {
+		t.Run(name, func(t *testing.T) {
+			values := map[string]string{}
+			for key, value := range enodeOptions.SetValues {
+				values[key] = value
+			}
+			values["bootstrapHostName"] = bootstrapHost
+			for key, value := range tc.values {
+				values[key] = value
+			}
+			err := helm.InstallE(t, &helm.Options{KubectlOptions: kubectlOptions, SetValues: values}, helmChartPath, enodeReleaseName)
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected the installation to fail with %q, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	// Verify a release joining a group with another XDQP SSL setting fails its compatibility check
+	enodeOptions.SetValues["bootstrapHostName"] = bootstrapHost
+	enodeOptions.SetValues["group.name"] = "dnode"
+	enodeOptions.SetValues["group.enableXdqpSsl"] = "false"
+	helm.Install(t, enodeOptions, helmChartPath, enodeReleaseName)
+	_, err = testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, enodeReleaseName+"-0", "compatibility", 30, 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "The group dnode of the cluster has XDQP SSL enabled true, group.enableXdqpSsl is false in this release") {
+		t.Errorf("Expected the compatibility check to fail, got: %v", err)
+	}
 }
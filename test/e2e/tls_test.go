@@ -605,3 +605,325 @@ func TestTlsOnEDnode(t *testing.T) {
 	// restart all pods at once in the cluster and verify its ready and MarkLogic server is healthy
 	testUtil.RestartPodAndVerify(t, true, []string{dnodePodName, enodePodName0, enodePodName1}, namespaceName, kubectlOptions, &tlsConfig)
 }
+
+func TestTLSOnAdditionalAppServer(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+	username := "admin"
+	password := "admin"
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "marklogic-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	// test-server is created on port 8010 by a post-bootstrap hook, then TLS is enabled on it
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":              "true",
+			"replicaCount":                     "2",
+			"image.repository":                 imageRepo,
+			"image.tag":                        imageTag,
+			"auth.adminUsername":               username,
+			"auth.adminPassword":               password,
+			"logCollection.enabled":            "false",
+			"tls.enableOnDefaultAppServers":    "true",
+			"tls.appServers[0].name":           "test-server",
+			"tls.appServers[0].port":           "8010",
+			"hooks.postBootstrap[0].name":      "create-test-server",
+			"hooks.postBootstrap[0].configMap": "test-server",
+			"hooks.postBootstrap[0].key":       "test-server.json",
+			"hooks.postBootstrap[0].type":      "manage",
+			"hooks.postBootstrap[0].path":      "/manage/v2/servers?group-id=Default&server-type=http",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	k8s.RunKubectl(t, kubectlOptions, "create", "configmap", "test-server", "--from-file=../test_data/path_based_test_data/test-server.json")
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-tls-app"
+	helm.Install(t, options, helmChartPath, releaseName)
+	podOneName := releaseName + "-1"
+	tlsConfig := tls.Config{InsecureSkipVerify: true}
+
+	// wait until the second pod is in Ready status
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podOneName, 15, 20*time.Second)
+	_, err := testUtil.MLReadyCheck(t, kubectlOptions, podOneName, &tlsConfig)
+	if err != nil {
+		t.Fatal("MarkLogic failed to start")
+	}
+
+	// the App Server uses the certificate template of the chart
+	tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, releaseName+"-0", 8002, 8002)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+	client := req.C().EnableInsecureSkipVerify().
+		SetCommonDigestAuth(username, password).
+		SetCommonRetryCount(10).
+		SetCommonRetryFixedInterval(10 * time.Second)
+	resp, err := client.R().
+		AddRetryCondition(func(resp *req.Response, err error) bool {
+			return err != nil || !strings.Contains(resp.String(), "defaultTemplate")
+		}).
+		Get(fmt.Sprintf("https://%s/manage/v2/servers/test-server/properties?group-id=Default&format=json", tunnel.Endpoint()))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if template := gjson.Get(resp.String(), `ssl-certificate-template`).String(); template != "defaultTemplate" {
+		t.Fatalf("Expected the certificate template defaultTemplate on test-server, got %q", template)
+	}
+
+	// every host of the group serves test-server over HTTPS only
+	appTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, podOneName, 8010, 8010)
+	defer appTunnel.Close()
+	appTunnel.ForwardPort(t)
+	resp, err = client.R().Get(fmt.Sprintf("https://%s/", appTunnel.Endpoint()))
+	if err != nil {
+		t.Fatalf("HTTPS request to test-server failed: %s", err.Error())
+	}
+	t.Logf("HTTPS response of test-server: %d", resp.GetStatusCode())
+	resp, err = req.C().SetCommonBasicAuth(username, password).R().Get(fmt.Sprintf("http://%s/", appTunnel.Endpoint()))
+	if err == nil && resp.GetStatusCode() < 400 {
+		t.Fatalf("Expected test-server to refuse plain HTTP, got %d", resp.GetStatusCode())
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+	username := "admin"
+	password := "admin"
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	// generate a local client CA, a client certificate it signs and one signed by a CA MarkLogic does not trust
+	clientCA := testUtil.NewCertificateAuthority(t, "client-ca")
+	trusted := clientCA.Issue(t, username)
+	untrusted := testUtil.NewCertificateAuthority(t, "untrusted-ca").Issue(t, username)
+	caPath := filepath.Join(t.TempDir(), "cacert.pem")
+	if err := os.WriteFile(caPath, clientCA.CertPEM, 0600); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	namespaceName := "marklogic-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	// test-server is created on port 8010 by a post-bootstrap hook, then requires client certificates
+	// and is exposed by HAProxy, which forwards the TLS connections unchanged
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":                        "true",
+			"replicaCount":                               "1",
+			"image.repository":                           imageRepo,
+			"image.tag":                                  imageTag,
+			"auth.adminUsername":                         username,
+			"auth.adminPassword":                         password,
+			"logCollection.enabled":                      "false",
+			"tls.enableOnDefaultAppServers":              "true",
+			"tls.appServers[0].name":                     "test-server",
+			"tls.appServers[0].port":                     "8010",
+			"tls.appServers[0].clientCertRequired":       "true",
+			"tls.clientAuth.caSecretName":                "client-ca",
+			"hooks.postBootstrap[0].name":                "create-test-server",
+			"hooks.postBootstrap[0].configMap":           "test-server",
+			"hooks.postBootstrap[0].key":                 "test-server.json",
+			"hooks.postBootstrap[0].type":                "manage",
+			"hooks.postBootstrap[0].path":                "/manage/v2/servers?group-id=Default&server-type=http",
+			"haproxy.enabled":                            "true",
+			"haproxy.replicaCount":                       "1",
+			"haproxy.clientAuth.mode":                    "passthrough",
+			"haproxy.additionalAppServers[0].name":       "test-server",
+			"haproxy.additionalAppServers[0].type":       "HTTP",
+			"haproxy.additionalAppServers[0].port":       "8010",
+			"haproxy.additionalAppServers[0].targetPort": "8010",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	k8s.RunKubectl(t, kubectlOptions, "create", "secret", "generic", "client-ca", "--from-file=cacert.pem="+caPath)
+	k8s.RunKubectl(t, kubectlOptions, "create", "configmap", "test-server", "--from-file=../test_data/path_based_test_data/test-server.json")
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-mtls"
+	podName := testUtil.HelmInstall(t, options, releaseName, kubectlOptions, helmChartPath)
+	tlsConfig := tls.Config{InsecureSkipVerify: true}
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podName, 15, 20*time.Second)
+	_, err := testUtil.MLReadyCheck(t, kubectlOptions, podName, &tlsConfig)
+	if err != nil {
+		t.Fatal("MarkLogic failed to start")
+	}
+
+	podTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, podName, 8010, 8010)
+	defer podTunnel.Close()
+	podTunnel.ForwardPort(t)
+	haproxyTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypeService, releaseName+"-haproxy", 0, 8010)
+	defer haproxyTunnel.Close()
+	haproxyTunnel.ForwardPort(t)
+
+	request := func(endpoint string, certificates ...tls.Certificate) error {
+		client := req.C().SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true, Certificates: certificates}).
+			SetCommonBasicAuth(username, password)
+		_, err := client.R().Get(fmt.Sprintf("https://%s/", endpoint))
+		return err
+	}
+
+	for name, endpoint := range map[string]string{"MarkLogic": podTunnel.Endpoint(), "HAProxy": haproxyTunnel.Endpoint()} {
+		// the handshake succeeds with a certificate of the client CA, retrying while the App Server is being configured
+		for attempt := 0; ; attempt++ {
+			err = request(endpoint, trusted.TLSCertificate(t))
+			if err == nil || attempt == 10 {
+				break
+			}
+			t.Logf("Waiting for test-server to accept client certificates: %s", err.Error())
+			time.Sleep(10 * time.Second)
+		}
+		if err != nil {
+			t.Fatalf("%s rejected the client certificate of the client CA: %s", name, err.Error())
+		}
+
+		// the handshake fails without a certificate or with a certificate of another CA
+		if err = request(endpoint); err == nil {
+			t.Errorf("%s accepted a connection without client certificate", name)
+		}
+		if err = request(endpoint, untrusted.TLSCertificate(t)); err == nil {
+			t.Errorf("%s accepted a client certificate of an untrusted CA", name)
+		}
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	// generate the certificate HAProxy presents on its TLS binds
+	haproxyCert := testUtil.NewCertificateAuthority(t, "haproxy-ca").Issue(t, "haproxy", "localhost")
+	certPath := filepath.Join(t.TempDir(), "haproxy.pem")
+	if err := os.WriteFile(certPath, haproxyCert.PEM(), 0600); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	namespaceName := "marklogic-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	options := &helm.Options{
+		KubectlOptions: kubectlOptions,
+		SetValues: map[string]string{
+			"persistence.enabled":           "true",
+			"replicaCount":                  "1",
+			"image.repository":              imageRepo,
+			"image.tag":                     imageTag,
+			"auth.adminUsername":            "admin",
+			"auth.adminPassword":            "admin",
+			"logCollection.enabled":         "false",
+			"tls.enableOnDefaultAppServers": "true",
+			"tls.policy":                    "intermediate",
+			"haproxy.enabled":               "true",
+			"haproxy.replicaCount":          "1",
+			"haproxy.pathbased.enabled":     "false",
+			"haproxy.tls.enabled":           "true",
+			"haproxy.tls.secretName":        "haproxy-cert",
+			"haproxy.tls.certFileName":      "haproxy.pem",
+		},
+	}
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	k8s.RunKubectl(t, kubectlOptions, "create", "secret", "generic", "haproxy-cert", "--from-file=haproxy.pem="+certPath)
+
+	t.Logf("====Installing Helm Chart")
+	releaseName := "test-tls-policy"
+	podName := testUtil.HelmInstall(t, options, releaseName, kubectlOptions, helmChartPath)
+	tlsConfig := tls.Config{InsecureSkipVerify: true}
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, podName, 15, 20*time.Second)
+	_, err := testUtil.MLReadyCheck(t, kubectlOptions, podName, &tlsConfig)
+	if err != nil {
+		t.Fatal("MarkLogic failed to start")
+	}
+
+	podTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, podName, 0, 8002)
+	defer podTunnel.Close()
+	podTunnel.ForwardPort(t)
+	haproxyTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypeService, releaseName+"-haproxy", 0, 8002)
+	defer haproxyTunnel.Close()
+	haproxyTunnel.ForwardPort(t)
+
+	handshake := func(endpoint string, version uint16) error {
+		conn, err := tls.Dial("tcp", endpoint, &tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	for name, endpoint := range map[string]string{"MarkLogic": podTunnel.Endpoint(), "HAProxy": haproxyTunnel.Endpoint()} {
+		// the policy is applied to the default App Servers after the bootstrap, retry until TLS 1.1 is refused
+		for attempt := 0; handshake(endpoint, tls.VersionTLS11) == nil; attempt++ {
+			if attempt == 10 {
+				t.Fatalf("%s still accepts TLS 1.1 handshakes", name)
+			}
+			t.Logf("Waiting for the TLS policy to be applied to %s", name)
+			time.Sleep(10 * time.Second)
+		}
+		if err = handshake(endpoint, tls.VersionTLS10); err == nil {
+			t.Errorf("%s accepted a TLS 1.0 handshake", name)
+		}
+		if err = handshake(endpoint, tls.VersionTLS12); err != nil {
+			t.Errorf("%s refused a TLS 1.2 handshake: %s", name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/imroc/req/v3"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/tidwall/gjson"
+)
+
+// createXdqpSecret creates a secret with an XDQP certificate of the CA for the hosts of the releases
+func createXdqpSecret(t *testing.T, kubectlOptions *k8s.KubectlOptions, name string, ca *testUtil.CertificateAuthority, releases ...string) testUtil.Certificate {
+	var dnsNames []string
+	for _, release := range releases {
+		dnsNames = append(dnsNames, fmt.Sprintf("*.%s.%s.svc.cluster.local", release, kubectlOptions.Namespace))
+	}
+	certificate := ca.Issue(t, "marklogic", dnsNames...)
+	dir := t.TempDir()
+	files := map[string][]byte{"tls.crt": certificate.CertPEM, "tls.key": certificate.KeyPEM, "cacert.pem": ca.CertPEM}
+	args := []string{"create", "secret", "generic", name}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), content, 0600); err != nil {
+			t.Fatalf(err.Error())
+		}
+		args = append(args, fmt.Sprintf("--from-file=%s=%s", file, filepath.Join(dir, file)))
+	}
+	k8s.RunKubectl(t, kubectlOptions, args...)
+	return certificate
+}
+
+func TestXdqpCustomCertificate(t *testing.T) {
+	imageRepo, repoPres := os.LookupEnv("dockerRepository")
+	imageTag, tagPres := os.LookupEnv("dockerVersion")
+	// Path to the helm chart we will test
+	helmChartPath, e := filepath.Abs("../../charts")
+	if e != nil {
+		t.Fatalf(e.Error())
+	}
+
+	if !repoPres {
+		imageRepo = "progressofficial/marklogic-db"
+		t.Logf("No imageRepo variable present, setting to default value: " + imageRepo)
+	}
+
+	if !tagPres {
+		imageTag = "latest-11"
+		t.Logf("No imageTag variable present, setting to default value: " + imageTag)
+	}
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	kubectlOptions := k8s.NewKubectlOptions("", "", namespaceName)
+	dnodeReleaseName := "dnode"
+	enodeReleaseName := "enode"
+
+	t.Logf("====Creating namespace: " + namespaceName)
+	k8s.CreateNamespace(t, kubectlOptions, namespaceName)
+
+	defer t.Logf("====Deleting namespace: " + namespaceName)
+	defer k8s.DeleteNamespace(t, kubectlOptions, namespaceName)
+
+	// the XDQP certificate of the cluster covers the hosts of both releases, another one is issued for enode only
+	ca := testUtil.NewCertificateAuthority(t, "xdqp-ca")
+	certificate := createXdqpSecret(t, kubectlOptions, "xdqp-cert", ca, dnodeReleaseName, enodeReleaseName)
+	createXdqpSecret(t, kubectlOptions, "xdqp-other-cert", ca, enodeReleaseName)
+
+	values := func(group string, secretName string) map[string]string {
+		return map[string]string{
+			"persistence.enabled":          "true",
+			"replicaCount":                 "1",
+			"image.repository":             imageRepo,
+			"image.tag":                    imageTag,
+			"auth.adminUsername":           username,
+			"auth.adminPassword":           password,
+			"group.name":                   group,
+			"group.xdqpSsl.certSecretName": secretName,
+			"logCollection.enabled":        "false",
+		}
+	}
+
+	t.Logf("====Installing Helm Chart " + dnodeReleaseName)
+	dnodeOptions := &helm.Options{KubectlOptions: kubectlOptions, SetValues: values("dnode", "xdqp-cert")}
+	dnodePodName := testUtil.HelmInstall(t, dnodeOptions, dnodeReleaseName, kubectlOptions, helmChartPath)
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, dnodePodName, 15, 20*time.Second)
+	bootstrapHost, err := VerifyDnodeConfig(t, dnodePodName, kubectlOptions, "http")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// verify the cluster uses the XDQP certificate of the secret
+	tunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, dnodePodName, 0, 8000)
+	defer tunnel.Close()
+	tunnel.ForwardPort(t)
+	resp, err := req.C().SetCommonDigestAuth(username, password).R().
+		SetFormData(map[string]string{"xquery": `xquery version "1.0-ml";
+			import module namespace admin = "http://marklogic.com/xdmp/admin" at "/MarkLogic/admin.xqy";
+			admin:cluster-get-xdqp-ssl-certificate(admin:get-configuration())`}).
+		Post(fmt.Sprintf("http://%s/v1/eval", tunnel.Endpoint()))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	removeSpaces := func(s string) string { return strings.Join(strings.Fields(s), "") }
+	pem := removeSpaces(string(certificate.CertPEM))
+	if !strings.Contains(removeSpaces(resp.String()), pem) {
+		t.Errorf("The cluster does not use the XDQP certificate of the secret: %s", resp.String())
+	}
+
+	// a release with another XDQP certificate does not join the cluster
+	t.Logf("====Installing Helm Chart %s with another XDQP certificate", enodeReleaseName)
+	enodeValues := values("enode", "xdqp-other-cert")
+	enodeValues["bootstrapHostName"] = bootstrapHost
+	enodeOptions := &helm.Options{KubectlOptions: kubectlOptions, SetValues: enodeValues}
+	enodePodName := testUtil.HelmInstall(t, enodeOptions, enodeReleaseName, kubectlOptions, helmChartPath)
+	_, err = testUtil.WaitUntilBootstrapPhase(t, kubectlOptions, enodePodName, "xdqp-certificate", 30, 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "uses another XDQP certificate") {
+		t.Fatalf("Expected the XDQP certificate check to fail, got: %v", err)
+	}
+	helm.Delete(t, enodeOptions, enodeReleaseName, true)
+	k8s.RunKubectl(t, kubectlOptions, "delete", "pvc", "--selector", "app.kubernetes.io/instance="+enodeReleaseName)
+
+	// the release with the certificate of the cluster joins it
+	t.Logf("====Installing Helm Chart %s with the XDQP certificate of the cluster", enodeReleaseName)
+	enodeValues["group.xdqpSsl.certSecretName"] = "xdqp-cert"
+	enodePodName = testUtil.HelmInstall(t, enodeOptions, enodeReleaseName, kubectlOptions, helmChartPath)
+	k8s.WaitUntilPodAvailable(t, kubectlOptions, enodePodName, 45, 20*time.Second)
+	if _, err = testUtil.WaitUntilBootstrapCompleted(t, kubectlOptions, enodePodName, 30, 10*time.Second); err != nil {
+		t.Fatalf(err.Error())
+	}
+	manageTunnel := k8s.NewTunnel(kubectlOptions, k8s.ResourceTypePod, dnodePodName, 0, 8002)
+	defer manageTunnel.Close()
+	manageTunnel.ForwardPort(t)
+	resp, err = req.C().SetCommonDigestAuth(username, password).R().
+		Get(fmt.Sprintf("http://%s/manage/v2/hosts?format=json", manageTunnel.Endpoint()))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hosts := gjson.Get(resp.String(), `host-default-list.list-items.list-count.value`).Int(); hosts != 2 {
+		t.Errorf("Expected 2 hosts in the cluster, got %d", hosts)
+	}
+}
@@ -0,0 +1,369 @@
+package mlconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/marklogic/marklogic-kubernetes/tools/mlconfig"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBundle writes the files of a bundle, keyed by their path in the ml-config layout
+func writeBundle(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for path, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	}
+	return dir
+}
+
+// sampleBundle has resources whose file order is the reverse of their dependency order
+var sampleBundle = map[string]string{
+	"security/roles/a-app-admin.json":  `{"role-name":"app-admin","role":["app-writer"]}`,
+	"security/roles/b-app-writer.json": `{"role-name":"app-writer","role":["app-reader"]}`,
+	"security/roles/c-app-reader.json": `{"role-name":"app-reader","privilege":[{"privilege-name":"xdmp:eval","action":"http://marklogic.com/xdmp/privileges/xdmp-eval","kind":"execute"}]}`,
+	"security/users/app-user.json":     `{"user-name":"app-user","password":"secret","role":["app-reader"]}`,
+	"security/privileges/app-uri.json": `{"privilege-name":"app-uri","action":"/app/","kind":"uri"}`,
+	"databases/content.json":           `{"database-name":"app-content","schema-database":"app-schemas","range-element-index":[{"scalar-type":"string","localname":"id","namespace-uri":""}]}`,
+	"databases/schemas.json":           `{"database-name":"app-schemas"}`,
+	"forests/app-content/forest.json":  `{"forest-name":"app-content-1","database":"app-content"}`,
+	"servers/app.json":                 `{"server-name":"app","server-type":"http","port":8010,"content-database":"app-content","root":"/"}`,
+}
+
+// newFakeCluster returns a FakeManageAPI that stores the resources created with POST under their properties path,
+// the way the Manage API makes them available
+func newFakeCluster(t *testing.T) *testUtil.FakeManageAPI {
+	fake := testUtil.NewFakeManageAPI(t)
+	fake.Respond(http.MethodGet, "/manage/v2", http.StatusOK, "")
+	for _, kind := range mlconfig.Kinds {
+		kind := kind
+		fake.Handle(http.MethodPost, kind.Collection, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &payload))
+			fake.SetResource(kind.Collection+"/"+payload[kind.NameField].(string)+"/properties", string(body))
+			w.WriteHeader(http.StatusCreated)
+		})
+	}
+	return fake
+}
+
+func newClient(t *testing.T, fake *testUtil.FakeManageAPI) *mlconfig.Client {
+	client, err := mlconfig.NewClient(mlconfig.ClientOptions{
+		ManageURL: fake.URL(),
+		AdminURL:  fake.URL(),
+		Username:  "admin",
+		Password:  "admin",
+		Interval:  10 * time.Millisecond,
+		Timeout:   time.Second,
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func resourceNames(bundle *mlconfig.Bundle) []string {
+	var names []string
+	for _, resource := range bundle.Resources {
+		names = append(names, resource.String())
+	}
+	return names
+}
+
+func TestLoadBundleDependencyOrder(t *testing.T) {
+	files := map[string]string{
+		"tasks/backup.json":          `{"task-type":"daily"}`,
+		"security/roles/README.md":   "roles of the app",
+		"security/amps/amp.json":     `{"local-name":"f"}`,
+		"servers/.hidden.json":       `{"server-name":"hidden"}`,
+		"forests/..data/forest.json": `{"forest-name":"linked"}`,
+	}
+	for path, content := range sampleBundle {
+		files[path] = content
+	}
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, files))
+	require.NoError(t, err)
+
+	// security objects come first, referenced roles and databases before those referencing them
+	require.Equal(t, []string{
+		"privilege app-uri",
+		"role app-reader",
+		"role app-writer",
+		"role app-admin",
+		"user app-user",
+		"database app-schemas",
+		"database app-content",
+		"forest app-content-1",
+		"server app (group Default)",
+	}, resourceNames(bundle))
+
+	// unsupported parts of the layout are reported, hidden files are ignored
+	require.Equal(t, []string{"security/amps", "security/roles/README.md", "tasks"}, bundle.Skipped)
+}
+
+func TestLoadBundleErrors(t *testing.T) {
+	tests := map[string]struct {
+		files         map[string]string
+		expectedError string
+	}{
+		"cycle": {map[string]string{
+			"security/roles/a.json": `{"role-name":"a","role":["b"]}`,
+			"security/roles/b.json": `{"role-name":"b","role":["a"]}`,
+		}, "roles reference each other in a cycle: a -> b -> a"},
+		"missing name": {map[string]string{
+			"databases/content.json": `{"triggers-database":"Triggers"}`,
+		}, "databases/content.json: database payload has no database-name"},
+		"duplicate": {map[string]string{
+			"servers/a.json": `{"server-name":"app","group-name":"Default"}`,
+			"servers/b.json": `{"server-name":"app"}`,
+		}, "servers/b.json: server app (group Default) is also defined in servers/a.json"},
+		"invalid JSON": {map[string]string{
+			"groups/default.json": `{"group-name":`,
+		}, "groups/default.json: unexpected EOF"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := mlconfig.LoadBundle(writeBundle(t, tc.files))
+			require.Error(t, err)
+			require.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestApplyCreatesInDependencyOrder(t *testing.T) {
+	fake := newFakeCluster(t)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, sampleBundle))
+	require.NoError(t, err)
+
+	changes, err := mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{})
+	require.NoError(t, err)
+	require.Equal(t, "9 to create, 0 to update, 0 unchanged", mlconfig.Summary(changes))
+
+	var created []string
+	for _, request := range fake.ModifyingRequests() {
+		require.Equal(t, http.MethodPost, request.Method)
+		created = append(created, request.Path)
+	}
+	require.Equal(t, []string{
+		"/manage/v2/privileges",
+		"/manage/v2/roles",
+		"/manage/v2/roles",
+		"/manage/v2/roles",
+		"/manage/v2/users",
+		"/manage/v2/databases",
+		"/manage/v2/databases",
+		"/manage/v2/forests",
+		"/manage/v2/servers",
+	}, created)
+
+	// app servers and privileges are identified by their group and kind
+	servers := fake.RequestsTo(http.MethodPost, "/manage/v2/servers")
+	require.Equal(t, "Default", servers[0].Query.Get("group-id"))
+	require.Equal(t, "uri", fake.RequestsTo(http.MethodPost, "/manage/v2/privileges")[0].Query.Get("kind"))
+	require.Equal(t, "application/json", servers[0].Header.Get("Content-Type"))
+	require.JSONEq(t, sampleBundle["servers/app.json"], servers[0].Body)
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	fake := newFakeCluster(t)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, sampleBundle))
+	require.NoError(t, err)
+	client := newClient(t, fake)
+
+	_, err = mlconfig.Apply(client, bundle, mlconfig.Options{})
+	require.NoError(t, err)
+	applied := len(fake.ModifyingRequests())
+
+	// applying the same bundle again only reads the cluster
+	changes, err := mlconfig.Apply(client, bundle, mlconfig.Options{})
+	require.NoError(t, err)
+	require.Equal(t, "0 to create, 0 to update, 9 unchanged", mlconfig.Summary(changes))
+	require.Len(t, fake.ModifyingRequests(), applied)
+}
+
+func TestApplyUpdatesChangedProperties(t *testing.T) {
+	fake := newFakeCluster(t)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, map[string]string{
+		"databases/content.json": `{"database-name":"app-content","triggers-database":"Triggers","range-element-index":[{"scalar-type":"string","localname":"id","namespace-uri":""},{"scalar-type":"int","localname":"year","namespace-uri":""}]}`,
+		"servers/app.json":       `{"server-name":"app","port":8010,"content-database":"app-content","authentication":"basic"}`,
+	}))
+	require.NoError(t, err)
+
+	// the Manage API returns defaults for properties that are not in the bundle, numbers as strings
+	// and lists in its own order
+	fake.SetResource("/manage/v2/databases/app-content/properties", `{"database-name":"app-content","enabled":true,"triggers-database":"Triggers",
+		"range-element-index":[{"scalar-type":"int","localname":"year","namespace-uri":"","collation":"","range-value-positions":false},
+		{"scalar-type":"string","localname":"id","namespace-uri":"","collation":"http://marklogic.com/collation/","range-value-positions":false}]}`)
+	fake.SetResource("/manage/v2/servers/app/properties", `{"server-name":"app","port":"8010","content-database":"app-content","authentication":"digest","root":"/"}`)
+
+	var out bytes.Buffer
+	changes, err := mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{Out: &out})
+	require.NoError(t, err)
+	require.Equal(t, mlconfig.Unchanged, changes[0].Action)
+	require.Equal(t, mlconfig.Update, changes[1].Action)
+
+	// only the app server that differs is updated, with the payload of the bundle
+	modifying := fake.ModifyingRequests()
+	require.Len(t, modifying, 1)
+	require.Equal(t, http.MethodPut, modifying[0].Method)
+	require.Equal(t, "/manage/v2/servers/app/properties", modifying[0].Path)
+	require.Equal(t, "Default", modifying[0].Query.Get("group-id"))
+	require.JSONEq(t, `{"server-name":"app","port":8010,"content-database":"app-content","authentication":"basic"}`, modifying[0].Body)
+	require.Contains(t, out.String(), "~ update server app (group Default) (servers/app.json)\n    authentication: \"digest\" -> \"basic\"\n")
+}
+
+func TestApplyIgnoresWriteOnlyProperties(t *testing.T) {
+	fake := newFakeCluster(t)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, map[string]string{
+		"security/users/app-user.json": `{"user-name":"app-user","password":"secret","role":["app-reader"]}`,
+	}))
+	require.NoError(t, err)
+
+	// the Manage API never returns passwords
+	fake.SetResource("/manage/v2/users/app-user/properties", `{"user-name":"app-user","description":"","role":"app-reader"}`)
+	changes, err := mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{})
+	require.NoError(t, err)
+	require.Equal(t, mlconfig.Unchanged, changes[0].Action)
+	require.Empty(t, fake.ModifyingRequests())
+}
+
+func TestApplyDryRun(t *testing.T) {
+	fake := newFakeCluster(t)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, map[string]string{
+		"security/roles/app-reader.json": `{"role-name":"app-reader","description":"reads the app"}`,
+		"databases/content.json":         `{"database-name":"app-content","triggers-database":"Triggers"}`,
+		"servers/app.json":               `{"server-name":"app","port":8010}`,
+		"tasks/backup.json":              `{}`,
+	}))
+	require.NoError(t, err)
+	fake.SetResource("/manage/v2/databases/app-content/properties", `{"database-name":"app-content"}`)
+	fake.SetResource("/manage/v2/servers/app/properties", `{"server-name":"app","port":8010}`)
+
+	var out bytes.Buffer
+	changes, err := mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{DryRun: true, Out: &out})
+	require.NoError(t, err)
+	require.Equal(t, "1 to create, 1 to update, 1 unchanged", mlconfig.Summary(changes))
+	require.Empty(t, fake.ModifyingRequests())
+	require.Equal(t, strings.Join([]string{
+		"! skipped tasks, not supported",
+		"+ create role app-reader (security/roles/app-reader.json)",
+		`    description: "reads the app"`,
+		`    role-name: "app-reader"`,
+		"~ update database app-content (databases/content.json)",
+		`    triggers-database: (not set) -> "Triggers"`,
+		"= unchanged server app (group Default) (servers/app.json)",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestApplyStopsAtFailure(t *testing.T) {
+	fake := newFakeCluster(t)
+	fake.Respond(http.MethodPost, "/manage/v2/databases", http.StatusBadRequest, `{"errorResponse":{"message":"invalid schema-database"}}`)
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, sampleBundle))
+	require.NoError(t, err)
+
+	changes, err := mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{})
+	require.Error(t, err)
+	require.Equal(t, `failed to create database app-schemas: POST /manage/v2/databases returned 400: {"errorResponse":{"message":"invalid schema-database"}}`, err.Error())
+	require.Len(t, changes, 6)
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/manage/v2/servers"))
+}
+
+func TestApplyWaitsForRestart(t *testing.T) {
+	fake := newFakeCluster(t)
+	fake.SetResource("/manage/v2/groups/Default/properties", `{"group-name":"Default","xdqp-ssl-enabled":true}`)
+	fake.Respond(http.MethodPut, "/manage/v2/groups/Default/properties", http.StatusAccepted,
+		`{"restart":{"last-startup":[{"value":"2024-01-01T00:00:00.000000Z","host-id":"1"}]}}`)
+	var timestamps atomic.Int32
+	fake.Handle(http.MethodGet, "/admin/v1/timestamp", func(w http.ResponseWriter, _ *http.Request) {
+		// MarkLogic reports the old startup time until it has restarted
+		if timestamps.Add(1) < 3 {
+			_, _ = io.WriteString(w, "2024-01-01T00:00:00.000000Z")
+			return
+		}
+		_, _ = io.WriteString(w, "2024-01-01T00:01:00.000000Z")
+	})
+	bundle, err := mlconfig.LoadBundle(writeBundle(t, map[string]string{
+		"groups/default.json": `{"group-name":"Default","xdqp-ssl-enabled":false}`,
+	}))
+	require.NoError(t, err)
+
+	_, err = mlconfig.Apply(newClient(t, fake), bundle, mlconfig.Options{})
+	require.NoError(t, err)
+	require.Len(t, fake.RequestsTo(http.MethodGet, "/admin/v1/timestamp"), 3)
+}
+
+func TestApplyRestartNotDetected(t *testing.T) {
+	tests := map[string]struct {
+		response string
+		status   int
+		body     string
+		message  string
+	}{
+		// a host that is still restarting answers with an error page
+		"error page": {`{"restart":{"last-startup":[{"value":"2024-01-01T00:00:00.000000Z","host-id":"1"}]}}`,
+			http.StatusUnauthorized, "<error>401 Unauthorized</error>", "timed out"},
+		"same startup time": {`{"restart":{"last-startup":[{"value":"2024-01-01T00:00:00.000000Z","host-id":"1"}]}}`,
+			http.StatusOK, "2024-01-01T00:00:00Z", "timed out"},
+		"no last-startup": {`{"restart":{}}`, http.StatusOK, "2024-01-01T00:01:00Z",
+			"no last-startup time in the response of a change that restarts MarkLogic"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeCluster(t)
+			fake.SetResource("/manage/v2/groups/Default/properties", `{"group-name":"Default","xdqp-ssl-enabled":true}`)
+			fake.Respond(http.MethodPut, "/manage/v2/groups/Default/properties", http.StatusAccepted, tc.response)
+			fake.Respond(http.MethodGet, "/admin/v1/timestamp", tc.status, tc.body)
+			bundle, err := mlconfig.LoadBundle(writeBundle(t, map[string]string{
+				"groups/default.json": `{"group-name":"Default","xdqp-ssl-enabled":false}`,
+			}))
+			require.NoError(t, err)
+
+			client := newClient(t, fake)
+			client.Timeout = 50 * time.Millisecond
+			_, err = mlconfig.Apply(client, bundle, mlconfig.Options{})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	decode := func(s string) map[string]interface{} {
+		var object map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(s), &object))
+		return object
+	}
+	tests := map[string]struct {
+		desired  string
+		current  string
+		expected []string
+	}{
+		"number as string": {`{"port":8010}`, `{"port":"8010"}`, nil},
+		"nested defaults":  {`{"index":{"localname":"id"}}`, `{"index":{"localname":"id","collation":""}}`, nil},
+		"list order":       {`{"role":["a","b"]}`, `{"role":["b","a"]}`, nil},
+		"single item":      {`{"role":["a"]}`, `{"role":"a"}`, nil},
+		"list length":      {`{"role":["a"]}`, `{"role":["a","b"]}`, []string{`role: ["a","b"] -> ["a"]`}},
+		"missing":          {`{"enabled":false}`, `{}`, []string{`enabled: (not set) -> false`}},
+		"write only":       {`{"password":"x"}`, `{}`, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var diffs []string
+			for _, diff := range mlconfig.Compare(decode(tc.desired), decode(tc.current), []string{"password"}) {
+				diffs = append(diffs, diff.String())
+			}
+			require.Equal(t, tc.expected, diffs)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package scripts_test
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const appServerTLSScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+reconcile_app_server_tls
+`
+
+// renderAppServerTLSScripts renders the chart scripts with TLS on the App Servers app, requiring client
+// certificates with certificate authentication, and jobs in the group enode with the certificate template corp
+func renderAppServerTLSScripts(t *testing.T) string {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"tls.enableOnDefaultAppServers":         "true",
+			"tls.appServers[0].name":                "app",
+			"tls.appServers[0].port":                "8010",
+			"tls.appServers[0].clientCertRequired":  "true",
+			"tls.appServers[0].authentication":      "certificate",
+			"tls.clientAuth.caSecretName":           "client-ca",
+			"tls.appServers[1].name":                "jobs",
+			"tls.appServers[1].port":                "8011",
+			"tls.appServers[1].group":               "enode",
+			"tls.appServers[1].certificateTemplate": "corp",
+		},
+	}
+	return testUtil.RenderHelmScripts(t, options, helmChartPath, "tls")
+}
+
+func TestAppServerTLSEnabled(t *testing.T) {
+	scriptsDir := renderAppServerTLSScripts(t)
+	fake := newFakeMarkLogic(t)
+	// changing the certificate template of jobs restarts MarkLogic
+	fake.Respond(http.MethodPut, "/manage/v2/servers/jobs/properties", http.StatusAccepted,
+		`{"restart":{"last-startup":[{"value":"2024-01-01T00:00:00Z","host-id":"1"}]}}`)
+	fake.RestartOn(http.MethodPut, "/manage/v2/servers/jobs/properties")
+	env := reconcileEnv(fake, nil, nil)
+	env["CLIENT_CA_PATH"] = t.TempDir()
+	writeSecret(t, env["CLIENT_CA_PATH"], map[string]string{"cacert.pem": "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, appServerTLSScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "TLS enabled on 2 App Servers")
+
+	app := fake.RequestsTo(http.MethodPut, "/manage/v2/servers/app/properties")
+	require.Len(t, app, 1)
+	require.Equal(t, "Default", app[0].Query.Get("group-id"))
+	require.JSONEq(t, `{"authentication":"certificate","ssl-certificate-template":"defaultTemplate","ssl-require-client-certificate":true}`, app[0].Body)
+	jobs := fake.RequestsTo(http.MethodPut, "/manage/v2/servers/jobs/properties")
+	require.Len(t, jobs, 1)
+	require.Equal(t, "enode", jobs[0].Query.Get("group-id"))
+	require.JSONEq(t, `{"ssl-certificate-template":"corp","ssl-require-client-certificate":false}`, jobs[0].Body)
+	require.Contains(t, output, "MarkLogic has restarted.")
+
+	// the client CA is trusted by the App Server requiring client certificates only
+	eval := fake.RequestsTo(http.MethodPost, "/v1/eval")
+	require.Len(t, eval, 1)
+	form, err := url.ParseQuery(eval[0].Body)
+	require.NoError(t, err)
+	require.Contains(t, form.Get("xquery"), "pki:insert-trusted-certificates($pem)")
+	require.Contains(t, form.Get("xquery"), "admin:appserver-set-ssl-client-certificate-authorities")
+	require.JSONEq(t, `{"pem":"-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----","servers":"app|Default"}`, form.Get("vars"))
+}
+
+func TestAppServerTLSWaitsForRestart(t *testing.T) {
+	scriptsDir := renderAppServerTLSScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPut, "/manage/v2/servers/jobs/properties", http.StatusAccepted,
+		`{"restart":{"last-startup":[{"value":"2024-01-01T00:00:00Z","host-id":"1"}]}}`)
+	// MarkLogic has not restarted yet when security answers the timestamp service with an error page
+	fake.Handle(http.MethodGet, "/admin/v1/timestamp", func(w http.ResponseWriter, _ *http.Request) {
+		if len(fake.RequestsTo(http.MethodPut, "/manage/v2/servers/jobs/properties")) == 0 {
+			_, _ = io.WriteString(w, "2024-01-01T00:00:00Z")
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, "<error>401 Unauthorized</error>")
+	})
+	env := reconcileEnv(fake, nil, nil)
+	env["CLIENT_CA_PATH"] = t.TempDir()
+	writeSecret(t, env["CLIENT_CA_PATH"], map[string]string{"cacert.pem": "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, appServerTLSScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to restart localhost")
+	require.NotContains(t, output, "TLS enabled on")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/v1/eval"))
+}
+
+func TestAppServerTLSMissingClientCA(t *testing.T) {
+	scriptsDir := renderAppServerTLSScripts(t)
+	fake := newFakeMarkLogic(t)
+	env := reconcileEnv(fake, nil, nil)
+	env["CLIENT_CA_PATH"] = t.TempDir()
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, appServerTLSScript)
+	require.Error(t, err)
+	require.Contains(t, output, "App Servers app|Default require client certificates but "+env["CLIENT_CA_PATH"]+"/cacert.pem is missing")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/v1/eval"))
+}
+
+func TestAppServerTLSMissingAppServer(t *testing.T) {
+	scriptsDir := renderAppServerTLSScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPut, "/manage/v2/servers/app/properties", http.StatusNotFound, "")
+	env := reconcileEnv(fake, nil, nil)
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, appServerTLSScript)
+	require.Error(t, err)
+	require.Contains(t, output, "App Server app does not exist in group Default, it must be created before TLS is enabled on it")
+	require.Empty(t, fake.RequestsTo(http.MethodPut, "/manage/v2/servers/jobs/properties"))
+}
@@ -0,0 +1,162 @@
+package scripts_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const externalSecurityScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+reconcile_external_security
+`
+
+// renderExternalSecurityScripts renders the chart scripts with the external security configuration corp-ldap,
+// mapping one LDAP group to app-reader and assigned to App-Services and app in the group enode
+func renderExternalSecurityScripts(t *testing.T) string {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"externalSecurity.enabled":                         "true",
+			"externalSecurity.name":                            "corp-ldap",
+			"externalSecurity.ldap.serverURI":                  "ldap://openldap:1389",
+			"externalSecurity.ldap.base":                       "ou=users\\,dc=example\\,dc=org",
+			"externalSecurity.ldap.bindSecretName":             "ldap-bind",
+			"externalSecurity.roleMapping[0].role":             "app-reader",
+			"externalSecurity.roleMapping[0].externalNames[0]": "cn=readers\\,ou=users\\,dc=example\\,dc=org",
+			"externalSecurity.appServers[0].name":              "App-Services",
+			"externalSecurity.appServers[1].name":              "app",
+			"externalSecurity.appServers[1].group":             "enode",
+		},
+	}
+	return testUtil.RenderHelmScripts(t, options, helmChartPath, "ldap")
+}
+
+func externalSecurityEnv(t *testing.T, fake *testUtil.FakeManageAPI, bind map[string]string) map[string]string {
+	bindDir := t.TempDir()
+	writeSecret(t, bindDir, bind)
+	return map[string]string{
+		"MARKLOGIC_ADMIN_USERNAME": "admin",
+		"MARKLOGIC_ADMIN_PASSWORD": "admin",
+		"MARKLOGIC_GROUP":          "Default",
+		"ADMIN_URL":                fake.URL(),
+		"MANAGE_URL":               fake.URL(),
+		"EVAL_URL":                 fake.URL(),
+		"LDAP_BIND_PATH":           bindDir,
+	}
+}
+
+func TestExternalSecurityCreated(t *testing.T) {
+	scriptsDir := renderExternalSecurityScripts(t)
+	fake := newFakeMarkLogic(t)
+	env := externalSecurityEnv(t, fake, map[string]string{"bind-dn": "cn=admin,dc=example,dc=org", "password": `pa"ss\word`})
+
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, externalSecurityScript)
+	require.NoError(t, err)
+
+	// the configuration is created with the bind credentials from the secret
+	created := fake.RequestsTo(http.MethodPost, "/manage/v2/external-security")
+	require.Len(t, created, 1)
+	require.JSONEq(t, `{
+		"external-security-name": "corp-ldap",
+		"description": "LDAP authentication",
+		"authentication": "ldap",
+		"authorization": "ldap",
+		"cache-timeout": 300,
+		"ldap-server": {
+			"ldap-server-uri": "ldap://openldap:1389",
+			"ldap-base": "ou=users,dc=example,dc=org",
+			"ldap-attribute": "uid",
+			"ldap-bind-method": "simple",
+			"ldap-default-user": "cn=admin,dc=example,dc=org",
+			"ldap-password": "pa\"ss\\word"
+		}
+	}`, created[0].Body)
+
+	// the LDAP group is mapped to the role
+	roles := fake.RequestsTo(http.MethodPut, "/manage/v2/roles/app-reader/properties")
+	require.Len(t, roles, 1)
+	require.JSONEq(t, `{"external-name":["cn=readers,ou=users,dc=example,dc=org"]}`, roles[0].Body)
+
+	// the configuration is assigned to the App Servers in their groups
+	servers := fake.RequestsTo(http.MethodPut, "/manage/v2/servers/App-Services/properties")
+	require.Len(t, servers, 1)
+	require.Equal(t, "Default", servers[0].Query.Get("group-id"))
+	require.JSONEq(t, `{"authentication":"basic","external-security":["corp-ldap"],"internal-security":true}`, servers[0].Body)
+	servers = fake.RequestsTo(http.MethodPut, "/manage/v2/servers/app/properties")
+	require.Len(t, servers, 1)
+	require.Equal(t, "enode", servers[0].Query.Get("group-id"))
+	require.Len(t, fake.ModifyingRequests(), 4)
+}
+
+func TestExternalSecurityUpdated(t *testing.T) {
+	scriptsDir := renderExternalSecurityScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.SetResource("/manage/v2/external-security/corp-ldap/properties", `{"external-security-name":"corp-ldap"}`)
+
+	// without a bind secret, the payload has no bind credentials
+	env := externalSecurityEnv(t, fake, nil)
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, externalSecurityScript)
+	require.NoError(t, err)
+
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/manage/v2/external-security"))
+	updated := fake.RequestsTo(http.MethodPut, "/manage/v2/external-security/corp-ldap/properties")
+	require.Len(t, updated, 1)
+	require.NotContains(t, updated[0].Body, "ldap-default-user")
+	require.NotContains(t, updated[0].Body, "ldap-password")
+}
+
+func TestExternalSecurityFailure(t *testing.T) {
+	scriptsDir := renderExternalSecurityScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPut, "/manage/v2/servers/App-Services/properties", http.StatusBadRequest, "")
+	env := externalSecurityEnv(t, fake, map[string]string{"bind-dn": "cn=admin,dc=example,dc=org", "password": "admin"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, externalSecurityScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to assign external security corp-ldap to App-Services, response code: 400")
+	require.Empty(t, fake.RequestsTo(http.MethodPut, "/manage/v2/servers/app/properties"))
+}
+
+func TestExternalSecurityReconciledWhenBindSecretChanges(t *testing.T) {
+	scriptsDir := renderExternalSecurityScripts(t)
+	fake := newFakeMarkLogic(t)
+	env := externalSecurityEnv(t, fake, map[string]string{"bind-dn": "cn=admin,dc=example,dc=org", "password": "old"})
+	env["NEW_PASSWORD"] = "new"
+
+	// the configuration is recorded with the old bind password, then the secret is rotated
+	script := `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+write_config_hashes "${status_file}"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}" || exit 1
+echo -n "${NEW_PASSWORD}" > "${LDAP_BIND_PATH}/password"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}"
+rc=$?
+echo "RECONCILED=${RECONCILED[*]}"
+exit ${rc}
+`
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=external_security\n")
+	created := fake.RequestsTo(http.MethodPost, "/manage/v2/external-security")
+	require.Len(t, created, 1)
+	require.Contains(t, created[0].Body, `"ldap-password":"new"`)
+
+	// hosts other than the bootstrap host leave the configuration of the cluster to it
+	fake = newFakeMarkLogic(t)
+	env = externalSecurityEnv(t, fake, map[string]string{"bind-dn": "cn=admin,dc=example,dc=org", "password": "old"})
+	env["NEW_PASSWORD"] = "new"
+	env["CLUSTER_TYPE"] = "non-bootstrap"
+	output, err = testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "external_security changed, reconciled by the bootstrap host")
+	require.Empty(t, fake.ModifyingRequests())
+}
@@ -0,0 +1,124 @@
+package scripts_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const publishHAProxyCAScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+K8S_SERVICE_ACCOUNT_PATH="${TEST_SERVICE_ACCOUNT_PATH}"
+K8S_API_SERVER="${TEST_K8S_API_SERVER}"
+publish_haproxy_ca
+`
+
+const haproxyCAConfigMapPath = "/api/v1/namespaces/marklogic/configmaps/marklogic-haproxy-ca"
+
+// haproxyCAEnv publishes to a fake Kubernetes API with the token of a fake service account
+func haproxyCAEnv(t *testing.T, fake *testUtil.FakeManageAPI, kubernetes *testUtil.FakeManageAPI) map[string]string {
+	serviceAccount := t.TempDir()
+	writeSecret(t, serviceAccount, map[string]string{"token": "service-account-token", "ca.crt": ""})
+	env := reconcileEnv(fake, nil, map[string]string{
+		"POD_NAMESPACE":             "marklogic",
+		"HAPROXY_CA_CONFIGMAP":      "marklogic-haproxy-ca",
+		"MARKLOGIC_CERTS_PATH":      t.TempDir(),
+		"TEST_SERVICE_ACCOUNT_PATH": serviceAccount,
+		"TEST_K8S_API_SERVER":       kubernetes.URL(),
+	})
+	return env
+}
+
+// publishedCA returns the ca.pem key of the merge patch of the ConfigMap
+func publishedCA(t *testing.T, request testUtil.RecordedRequest) string {
+	require.Equal(t, "Bearer service-account-token", request.Header.Get("Authorization"))
+	require.Equal(t, "application/merge-patch+json", request.Header.Get("Content-Type"))
+	patch := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(request.Body), &patch))
+	return patch.Data["ca.pem"]
+}
+
+func TestPublishHAProxyCASecret(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	kubernetes := testUtil.NewFakeManageAPI(t)
+	kubernetes.Respond(http.MethodPatch, haproxyCAConfigMapPath, http.StatusOK, "{}")
+	ca := testUtil.NewCertificateAuthority(t, "marklogic-ca")
+	env := haproxyCAEnv(t, fake, kubernetes)
+	// the CA of tls.caSecretName copied by the copy-certs init container
+	writeSecret(t, env["MARKLOGIC_CERTS_PATH"], map[string]string{"cacert.pem": string(ca.CertPEM)})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, publishHAProxyCAScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "published the CA of the App Servers to the ConfigMap marklogic-haproxy-ca")
+
+	patches := kubernetes.RequestsTo(http.MethodPatch, haproxyCAConfigMapPath)
+	require.Len(t, patches, 1)
+	require.Equal(t, string(ca.CertPEM), publishedCA(t, patches[0])+"\n")
+}
+
+func TestPublishHAProxyCATemporaryCertificate(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	kubernetes := testUtil.NewFakeManageAPI(t)
+	kubernetes.Respond(http.MethodPatch, haproxyCAConfigMapPath, http.StatusOK, "{}")
+
+	// the Admin App Server presents the temporary certificate of the host followed by the CA MarkLogic generated
+	ca := testUtil.NewCertificateAuthority(t, "temporary-ca")
+	certificate := ca.Issue(t, "marklogic-0.marklogic.marklogic.svc.cluster.local", "localhost")
+	chain, err := tls.X509KeyPair(append(append([]byte{}, certificate.CertPEM...), ca.CertPEM...), certificate.KeyPEM)
+	require.NoError(t, err)
+	admin := httptest.NewUnstartedServer(http.NotFoundHandler())
+	admin.TLS = &tls.Config{Certificates: []tls.Certificate{chain}}
+	admin.StartTLS()
+	defer admin.Close()
+
+	env := haproxyCAEnv(t, fake, kubernetes)
+	env["ADMIN_URL"] = admin.URL
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, publishHAProxyCAScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "published the CA of the App Servers to the ConfigMap marklogic-haproxy-ca")
+
+	patches := kubernetes.RequestsTo(http.MethodPatch, haproxyCAConfigMapPath)
+	require.Len(t, patches, 1)
+	published := publishedCA(t, patches[0])
+	require.Contains(t, published+"\n", string(ca.CertPEM))
+	require.Contains(t, published+"\n", string(certificate.CertPEM))
+}
+
+func TestPublishHAProxyCAFailures(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	tests := map[string]struct {
+		responseCode  int
+		withCA        bool
+		expectedError string
+	}{
+		"no ca":     {http.StatusOK, false, "Failed to read the CA of the App Servers"},
+		"forbidden": {http.StatusForbidden, true, "Failed to publish the CA of the App Servers to the ConfigMap marklogic-haproxy-ca, response code: 403"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeMarkLogic(t)
+			kubernetes := testUtil.NewFakeManageAPI(t)
+			kubernetes.Respond(http.MethodPatch, haproxyCAConfigMapPath, tc.responseCode, "{}")
+			env := haproxyCAEnv(t, fake, kubernetes)
+			if tc.withCA {
+				ca := testUtil.NewCertificateAuthority(t, "marklogic-ca")
+				writeSecret(t, env["MARKLOGIC_CERTS_PATH"], map[string]string{"cacert.pem": string(ca.CertPEM)})
+			}
+			// the fake Admin App Server does not speak TLS, it has no certificate chain
+			output, err := testUtil.RunHelmScript(t, scriptsDir, env, publishHAProxyCAScript)
+			require.Error(t, err)
+			require.Contains(t, output, tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package scripts_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const hooksScript = `
+source "${HELM_SCRIPTS_PATH}/post-bootstrap-hooks.sh"
+run_post_bootstrap_hooks
+`
+
+// renderHookScripts renders the chart scripts with hooks.postBootstrap set to create-roles (manage),
+// set-group (manage, PUT) and create-triggers (script), in that order.
+func renderHookScripts(t *testing.T) string {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"hooks.postBootstrap[0].name":      "create-roles",
+			"hooks.postBootstrap[0].configMap": "hooks",
+			"hooks.postBootstrap[0].key":       "roles.json",
+			"hooks.postBootstrap[0].type":      "manage",
+			"hooks.postBootstrap[0].path":      "/manage/v2/roles",
+			"hooks.postBootstrap[1].name":      "set-group",
+			"hooks.postBootstrap[1].configMap": "hooks",
+			"hooks.postBootstrap[1].key":       "group.xml",
+			"hooks.postBootstrap[1].type":      "manage",
+			"hooks.postBootstrap[1].method":    "PUT",
+			"hooks.postBootstrap[1].path":      "/manage/v2/groups/Default/properties",
+			"hooks.postBootstrap[2].name":      "create-triggers",
+			"hooks.postBootstrap[2].configMap": "hooks",
+			"hooks.postBootstrap[2].key":       "triggers.sh",
+			"hooks.postBootstrap[2].type":      "script",
+		},
+	}
+	return testUtil.RenderHelmScripts(t, options, helmChartPath, "hooks")
+}
+
+// writeHooks writes the hook files the way the projected volume mounts them
+func writeHooks(t *testing.T, hooksDir string) {
+	writeSecret(t, hooksDir, map[string]string{
+		"create-roles": `{"role-name":"app-reader"}`,
+		"set-group":    `<group-properties xmlns="http://marklogic.com/manage"/>`,
+		// the script calls the Manage API itself, so its request shows where it ran in the order
+		"create-triggers": `curl -s -f -o /dev/null --anyauth --user "${MARKLOGIC_ADMIN_USERNAME}:${MARKLOGIC_ADMIN_PASSWORD}" -X POST "${MANAGE_URL}/manage/v2/triggers-hook"`,
+	})
+}
+
+func hooksEnv(fake *testUtil.FakeManageAPI, hooksDir string, dataDir string) map[string]string {
+	return map[string]string{
+		"MARKLOGIC_ADMIN_USERNAME":  "admin",
+		"MARKLOGIC_ADMIN_PASSWORD":  "admin",
+		"MANAGE_URL":                fake.URL(),
+		"POST_BOOTSTRAP_HOOKS_PATH": hooksDir,
+		"ML_KUBERNETES_FILE_PATH":   dataDir,
+	}
+}
+
+func TestPostBootstrapHooksRunInOrder(t *testing.T) {
+	scriptsDir := renderHookScripts(t)
+	fake := testUtil.NewFakeManageAPI(t)
+	hooksDir, dataDir := t.TempDir(), t.TempDir()
+	writeHooks(t, hooksDir)
+
+	_, err := testUtil.RunHelmScript(t, scriptsDir, hooksEnv(fake, hooksDir, dataDir), hooksScript)
+	require.NoError(t, err)
+
+	requests := fake.Requests()
+	require.Len(t, requests, 3)
+	require.Equal(t, "POST", requests[0].Method)
+	require.Equal(t, "/manage/v2/roles", requests[0].Path)
+	require.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+	require.JSONEq(t, `{"role-name":"app-reader"}`, requests[0].Body)
+	require.Equal(t, "PUT", requests[1].Method)
+	require.Equal(t, "/manage/v2/groups/Default/properties", requests[1].Path)
+	require.Equal(t, "application/xml", requests[1].Header.Get("Content-Type"))
+	require.Equal(t, "/manage/v2/triggers-hook", requests[2].Path)
+
+	// a marker is written for each hook that succeeded
+	for _, name := range []string{"create-roles", "set-group", "create-triggers"} {
+		require.FileExists(t, filepath.Join(dataDir, "hooks", name))
+	}
+}
+
+func TestPostBootstrapHooksRunOnce(t *testing.T) {
+	scriptsDir := renderHookScripts(t)
+	fake := testUtil.NewFakeManageAPI(t)
+	hooksDir, dataDir := t.TempDir(), t.TempDir()
+	writeHooks(t, hooksDir)
+	env := hooksEnv(fake, hooksDir, dataDir)
+
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, hooksScript)
+	require.NoError(t, err)
+	require.Len(t, fake.Requests(), 3)
+
+	// hooks that already ran are skipped on restart
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, hooksScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "post-bootstrap hook create-roles already ran, skipping")
+	require.Len(t, fake.Requests(), 3)
+
+	// a hook runs again when its content changes
+	require.NoError(t, os.WriteFile(filepath.Join(hooksDir, "create-roles"), []byte(`{"role-name":"app-writer"}`), 0600))
+	_, err = testUtil.RunHelmScript(t, scriptsDir, env, hooksScript)
+	require.NoError(t, err)
+	requests := fake.Requests()
+	require.Len(t, requests, 4)
+	require.JSONEq(t, `{"role-name":"app-writer"}`, requests[3].Body)
+}
+
+func TestPostBootstrapHooksFailure(t *testing.T) {
+	scriptsDir := renderHookScripts(t)
+	fake := testUtil.NewFakeManageAPI(t)
+	fake.Respond(http.MethodPut, "/manage/v2/groups/Default/properties", http.StatusBadRequest, "invalid group property")
+	hooksDir, dataDir := t.TempDir(), t.TempDir()
+	writeHooks(t, hooksDir)
+	env := hooksEnv(fake, hooksDir, dataDir)
+
+	// the failing hook is reported and the remaining hooks are skipped
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, hooksScript+`rc=$?; echo "LAST_ERROR=${LAST_ERROR}"; exit $rc`)
+	require.Error(t, err)
+	require.Contains(t, output, "LAST_ERROR=post-bootstrap hook set-group failed: PUT /manage/v2/groups/Default/properties returned 400: invalid group property")
+	require.Len(t, fake.Requests(), 2)
+	require.FileExists(t, filepath.Join(dataDir, "hooks", "create-roles"))
+	require.NoFileExists(t, filepath.Join(dataDir, "hooks", "set-group"))
+
+	// the failed hook is retried on the next run, the succeeded one is not
+	fake.Respond(http.MethodPut, "/manage/v2/groups/Default/properties", http.StatusNoContent, "")
+	_, err = testUtil.RunHelmScript(t, scriptsDir, env, hooksScript)
+	require.NoError(t, err)
+	requests := fake.Requests()
+	require.Len(t, requests, 4)
+	require.Equal(t, "/manage/v2/groups/Default/properties", requests[2].Path)
+	require.Equal(t, "/manage/v2/triggers-hook", requests[3].Path)
+}
+
+func TestPostBootstrapHooksScriptFailure(t *testing.T) {
+	scriptsDir := renderHookScripts(t)
+	fake := testUtil.NewFakeManageAPI(t)
+	fake.Respond(http.MethodPost, "/manage/v2/triggers-hook", http.StatusInternalServerError, "")
+	hooksDir, dataDir := t.TempDir(), t.TempDir()
+	writeHooks(t, hooksDir)
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, hooksEnv(fake, hooksDir, dataDir), hooksScript)
+	require.Error(t, err)
+	require.Contains(t, output, "post-bootstrap hook create-triggers failed: script exited with 22")
+	require.NoFileExists(t, filepath.Join(dataDir, "hooks", "create-triggers"))
+}
+
+func TestPostBootstrapHooksReportedInBootstrapStatus(t *testing.T) {
+	scriptsDir := renderHookScripts(t)
+
+	// the end of the postStart hook, which exits 1 when the bootstrap failed
+	script := `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/post-bootstrap-hooks.sh"
+run_bootstrap_phase post-bootstrap-hooks run_post_bootstrap_hooks
+cat "${ML_KUBERNETES_FILE_PATH}/bootstrap-status.json"
+bootstrap_phase complete Succeeded "bootstrap completed" || exit 1
+cat "${ML_KUBERNETES_FILE_PATH}/bootstrap-status.json"
+`
+	tests := map[string]struct {
+		policy  string
+		state   string
+		message string
+	}{
+		// the failure is reported, MarkLogic keeps running and the hook is retried on the next start
+		"ignore": {"", `"state":"Succeeded"`, "ignored failed phases: post-bootstrap-hooks"},
+		// the failure fails the postStart hook, so the container restarts and retries it
+		"fail": {"Fail", `"state":"Failed"`, "failed phases: post-bootstrap-hooks"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := testUtil.NewFakeManageAPI(t)
+			fake.Respond(http.MethodPost, "/manage/v2/roles", http.StatusBadRequest, "")
+			hooksDir, dataDir := t.TempDir(), t.TempDir()
+			writeHooks(t, hooksDir)
+			env := hooksEnv(fake, hooksDir, dataDir)
+			env["POST_BOOTSTRAP_HOOKS_FAILURE_POLICY"] = tc.policy
+
+			output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+			require.Contains(t, output, `"post-bootstrap-hooks":"Failed"`)
+			status, readErr := os.ReadFile(filepath.Join(dataDir, "bootstrap-status.json"))
+			require.NoError(t, readErr)
+			require.Contains(t, string(status), tc.state)
+			require.Contains(t, string(status), tc.message)
+			if tc.policy == "Fail" {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
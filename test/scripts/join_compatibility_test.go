@@ -0,0 +1,95 @@
+package scripts_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const joinCompatibilityScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+check_join_compatibility "${BOOTSTRAP_PROTOCOL:-http}" "${JOIN_MANAGE_URL:-${MANAGE_URL}}"
+`
+
+func TestJoinCompatibility(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	tests := map[string]struct {
+		env           map[string]string
+		cluster       string
+		group         string
+		expectedError string
+	}{
+		"compatible": {
+			cluster: `{"local-cluster-default":{"name":"dnode-cluster","version":"11.3.1"}}`,
+			group:   `{"group-name":"Default","xdqp-ssl-enabled":true}`,
+		},
+		"new group": {
+			cluster: `{"local-cluster-default":{"name":"dnode-cluster","version":"11.3.1"}}`,
+		},
+		"cluster domain": {
+			env:           map[string]string{"MARKLOGIC_BOOTSTRAP_HOST": "dnode-0.dnode.default.svc.example.org"},
+			expectedError: "is not in the cluster domain cluster.local of this release",
+		},
+		"tls enabled on the bootstrap host": {
+			env:           map[string]string{"BOOTSTRAP_PROTOCOL": "https"},
+			expectedError: "has TLS enabled on its default App Servers, tls.enableOnDefaultAppServers must be true in this release",
+		},
+		"tls disabled on the bootstrap host": {
+			env:           map[string]string{"MARKLOGIC_JOIN_TLS_ENABLED": "true"},
+			expectedError: "has TLS disabled on its default App Servers, tls.enableOnDefaultAppServers must be false in this release",
+		},
+		"unreachable": {
+			env:           map[string]string{"JOIN_MANAGE_URL": "http://127.0.0.1:1"},
+			expectedError: "can not be reached, check bootstrapHostName",
+		},
+		"credentials": {
+			expectedError: "The admin credentials of this release are rejected by the bootstrap host",
+		},
+		"version": {
+			cluster:       `{"local-cluster-default":{"name":"dnode-cluster","version":"11.2.0"}}`,
+			expectedError: "runs MarkLogic 11.2.0, this release runs MarkLogic 11.3.1",
+		},
+		"group xdqp ssl": {
+			cluster:       `{"local-cluster-default":{"name":"dnode-cluster","version":"11.3.1"}}`,
+			group:         `{"group-name":"Default","xdqp-ssl-enabled":false}`,
+			expectedError: "The group Default of the cluster has XDQP SSL enabled false, group.enableXdqpSsl is true in this release",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeMarkLogic(t)
+			if tc.cluster != "" {
+				fake.SetResource("/manage/v2", tc.cluster)
+			} else {
+				fake.Respond(http.MethodGet, "/manage/v2", http.StatusUnauthorized, "")
+			}
+			if tc.group != "" {
+				fake.SetResource("/manage/v2/groups/Default/properties", tc.group)
+			}
+			env := reconcileEnv(fake, nil, map[string]string{
+				"MARKLOGIC_BOOTSTRAP_HOST":   "dnode-0.dnode.default.svc.cluster.local",
+				"HOST_FQDN":                  "enode-0.enode.default.svc.cluster.local",
+				"MARKLOGIC_JOIN_TLS_ENABLED": "false",
+				"MARKLOGIC_VERSION":          "11.3.1",
+				"XDQP_SSL_ENABLED":           "true",
+			})
+			for key, value := range tc.env {
+				env[key] = value
+			}
+
+			output, err := testUtil.RunHelmScript(t, scriptsDir, env, joinCompatibilityScript)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				require.Contains(t, output, "this release is compatible with the cluster of dnode-0.dnode.default.svc.cluster.local")
+			} else {
+				require.Error(t, err)
+				require.Contains(t, output, tc.expectedError)
+			}
+			// the check only reads the configuration of the cluster
+			require.Empty(t, fake.ModifyingRequests())
+		})
+	}
+}
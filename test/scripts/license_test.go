@@ -0,0 +1,130 @@
+package scripts_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+// licenseWatcherScript records the license given by the OLD_ variables in status.txt when STATUS_FILE is set
+// and marks poststart-hook.sh completed unless POSTSTART_RUNNING is set, then checks the mounted license secret twice.
+const licenseWatcherScript = `
+source "${HELM_SCRIPTS_PATH}/license-watcher.sh"
+if [[ -n "${STATUS_FILE}" ]]; then
+    (LICENSE_KEY="${OLD_LICENSE_KEY}" LICENSEE="${OLD_LICENSEE}" write_config_hashes "${ML_KUBERNETES_FILE_PATH}/status.txt")
+fi
+if [[ -z "${POSTSTART_RUNNING}" ]]; then
+    touch "${ML_KUBERNETES_FILE_PATH}/poststart-completed"
+fi
+check_license || exit 1
+check_license || exit 1
+grep "^license_hash=" "${ML_KUBERNETES_FILE_PATH}/status.txt" | wc -l | sed 's/^ */LICENSE_HASH_LINES=/'
+`
+
+// writeSecret writes the keys of a secret the way Kubernetes mounts them
+func writeSecret(t *testing.T, dir string, data map[string]string) {
+	for key, value := range data {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, key), []byte(value), 0600))
+	}
+}
+
+func licenseWatcherEnv(t *testing.T, fake *testUtil.FakeManageAPI, license map[string]string) map[string]string {
+	licenseDir := t.TempDir()
+	writeSecret(t, licenseDir, license)
+	secretsDir := t.TempDir()
+	writeSecret(t, secretsDir, map[string]string{"username": "admin", "password": "admin"})
+	return map[string]string{
+		"ADMIN_URL":       fake.URL(),
+		"LICENSE_PATH":    licenseDir,
+		"ML_SECRETS_PATH": secretsDir,
+		"STATUS_FILE":     "true",
+	}
+}
+
+func TestLicenseWatcherAppliesChangedSecret(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	env := licenseWatcherEnv(t, fake, map[string]string{"license-key": "new-key", "licensee": "new-licensee"})
+	env["OLD_LICENSE_KEY"] = "old-key"
+	env["OLD_LICENSEE"] = "old-licensee"
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, licenseWatcherScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "license secret changed, reapplying the license")
+
+	// the new license is applied once and recorded in status.txt
+	requests := fake.RequestsTo(http.MethodPost, "/admin/v1/init")
+	require.Len(t, requests, 1)
+	require.Contains(t, requests[0].Body, `"license-key" : "new-key"`)
+	require.Contains(t, requests[0].Body, `"licensee" : "new-licensee"`)
+	require.Contains(t, output, "LICENSE_HASH_LINES=1")
+}
+
+func TestLicenseWatcherUnchangedSecret(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	env := licenseWatcherEnv(t, fake, map[string]string{"license-key": "key", "licensee": "licensee"})
+	env["OLD_LICENSE_KEY"] = "key"
+	env["OLD_LICENSEE"] = "licensee"
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, licenseWatcherScript)
+	require.NoError(t, err)
+	require.Empty(t, fake.Requests())
+}
+
+func TestLicenseWatcherWaitsForBootstrap(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	// nothing is applied before poststart-hook.sh has written status.txt
+	env := licenseWatcherEnv(t, fake, map[string]string{"license-key": "key", "licensee": "licensee"})
+	delete(env, "STATUS_FILE")
+	script := `
+source "${HELM_SCRIPTS_PATH}/license-watcher.sh"
+check_license
+`
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Empty(t, fake.Requests())
+}
+
+func TestLicenseWatcherWaitsForPostStart(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	// poststart-hook.sh applies the license itself while the container starts
+	env := licenseWatcherEnv(t, fake, map[string]string{"license-key": "new-key", "licensee": "licensee"})
+	env["OLD_LICENSE_KEY"] = "old-key"
+	env["OLD_LICENSEE"] = "licensee"
+	env["POSTSTART_RUNNING"] = "true"
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, licenseWatcherScript)
+	require.NoError(t, err)
+	require.Empty(t, fake.Requests())
+}
+
+func TestLicenseWatcherRetriesFailedUpdate(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPost, "/admin/v1/init", http.StatusBadRequest, "")
+
+	env := licenseWatcherEnv(t, fake, map[string]string{"license-key": "new-key", "licensee": "licensee"})
+	env["OLD_LICENSE_KEY"] = "old-key"
+	env["OLD_LICENSEE"] = "licensee"
+	script := `
+source "${HELM_SCRIPTS_PATH}/license-watcher.sh"
+(LICENSE_KEY="${OLD_LICENSE_KEY}" LICENSEE="${OLD_LICENSEE}" write_config_hashes "${ML_KUBERNETES_FILE_PATH}/status.txt")
+touch "${ML_KUBERNETES_FILE_PATH}/poststart-completed"
+check_license
+check_license
+`
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to apply the license, response code: 400")
+
+	// the license hash is not updated, so every check tries again
+	require.Len(t, fake.RequestsTo(http.MethodPost, "/admin/v1/init"), 2)
+}
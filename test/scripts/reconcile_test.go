@@ -0,0 +1,330 @@
+package scripts_test
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+// reconcileScript records the configuration given by the OLD_ variables in a status file,
+// then reconciles it with the configuration given by the other variables.
+const reconcileScript = `
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+(
+    REALM="${OLD_REALM}" LICENSE_KEY="${OLD_LICENSE_KEY}" LICENSEE="${OLD_LICENSEE}" \
+    PATH_BASED_ROUTING="${OLD_PATH_BASED_ROUTING}" INSTALL_CONVERTERS="${OLD_INSTALL_CONVERTERS}" \
+    write_config_hashes "${status_file}"
+)
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}"
+rc=$?
+echo "RECONCILED=${RECONCILED[*]}"
+exit ${rc}
+`
+
+func reconcileEnv(fake *testUtil.FakeManageAPI, old map[string]string, current map[string]string) map[string]string {
+	env := map[string]string{
+		"MARKLOGIC_ADMIN_USERNAME": "admin",
+		"MARKLOGIC_ADMIN_PASSWORD": "admin",
+		"MARKLOGIC_GROUP":          "Default",
+		"ADMIN_URL":                fake.URL(),
+		"MANAGE_URL":               fake.URL(),
+		"EVAL_URL":                 fake.URL(),
+	}
+	for key, value := range old {
+		env["OLD_"+key] = value
+		env[key] = value
+	}
+	for key, value := range current {
+		env[key] = value
+	}
+	return env
+}
+
+func renderScripts(t *testing.T) string {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	return testUtil.RenderHelmScripts(t, &helm.Options{}, helmChartPath, "scripts")
+}
+
+func newFakeMarkLogic(t *testing.T) *testUtil.FakeManageAPI {
+	fake := testUtil.NewFakeManageAPI(t)
+	fake.Respond(http.MethodGet, "/admin/v1/timestamp", http.StatusOK, "2024-01-01T00:00:00Z")
+	fake.Respond(http.MethodPost, "/admin/v1/init", http.StatusNoContent, "")
+	fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, "")
+	return fake
+}
+
+func TestReconcileNoDrift(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	config := map[string]string{"REALM": "public", "LICENSE_KEY": "key", "LICENSEE": "licensee", "PATH_BASED_ROUTING": "true"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, config, nil), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "no configuration drift detected")
+	require.Contains(t, output, "RECONCILED=\n")
+	require.Empty(t, fake.Requests())
+}
+
+func TestReconcileLicenseChange(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	old := map[string]string{"LICENSE_KEY": "old-key", "LICENSEE": "old-licensee"}
+	current := map[string]string{"LICENSE_KEY": "new-key", "LICENSEE": "new-licensee"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=license\n")
+
+	// only the license is reapplied, with the new values
+	modifying := fake.ModifyingRequests()
+	require.Len(t, modifying, 1)
+	require.Equal(t, "/admin/v1/init", modifying[0].Path)
+	require.Contains(t, modifying[0].Body, `"license-key" : "new-key"`)
+	require.Contains(t, modifying[0].Body, `"licensee" : "new-licensee"`)
+}
+
+func TestReconcileLicenseChangeWithRestart(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPost, "/admin/v1/init", http.StatusAccepted, "<last-startup>2024-01-01T00:00:00Z</last-startup>")
+	fake.RestartOn(http.MethodPost, "/admin/v1/init")
+
+	old := map[string]string{"LICENSE_KEY": "old-key", "LICENSEE": "licensee"}
+	current := map[string]string{"LICENSE_KEY": "new-key"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "MarkLogic has restarted.")
+	require.Contains(t, output, "RECONCILED=license\n")
+}
+
+func TestReconcileLicenseWaitsForRestart(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	old := map[string]string{"LICENSE_KEY": "old-key", "LICENSEE": "licensee"}
+	current := map[string]string{"LICENSE_KEY": "new-key"}
+	tests := map[string]func(fake *testUtil.FakeManageAPI){
+		// MarkLogic keeps answering with the startup time before the license was applied
+		"same startup time": func(*testUtil.FakeManageAPI) {},
+		// the error page of a host that is still restarting is not a new startup time
+		"unauthorized": func(fake *testUtil.FakeManageAPI) {
+			fake.Handle(http.MethodGet, "/admin/v1/timestamp", func(w http.ResponseWriter, _ *http.Request) {
+				if len(fake.ModifyingRequests()) == 0 {
+					_, _ = io.WriteString(w, "2024-01-01T00:00:00Z")
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = io.WriteString(w, "<error>401 Unauthorized</error>")
+			})
+		},
+	}
+	for name, setup := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeMarkLogic(t)
+			fake.Respond(http.MethodPost, "/admin/v1/init", http.StatusAccepted, "<last-startup>2024-01-01T00:00:00Z</last-startup>")
+			setup(fake)
+			output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+			require.Error(t, err)
+			require.Contains(t, output, "Failed to restart localhost")
+			require.NotContains(t, output, "MarkLogic has restarted.")
+			require.Contains(t, output, "RECONCILED=\n")
+		})
+	}
+}
+
+func TestReconcileRealmChange(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	old := map[string]string{"REALM": "public"}
+	current := map[string]string{"REALM": "marklogic"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=realm\n")
+
+	// the realm is set in the Security database
+	modifying := fake.ModifyingRequests()
+	require.Len(t, modifying, 1)
+	require.Equal(t, "/v1/eval", modifying[0].Path)
+	require.Equal(t, "Security", modifying[0].Query.Get("database"))
+	form, err := url.ParseQuery(modifying[0].Body)
+	require.NoError(t, err)
+	require.Contains(t, form.Get("xquery"), "sec:set-realm($realm)")
+	require.Equal(t, `{"realm":"marklogic"}`, form.Get("vars"))
+}
+
+func TestReconcilePathBasedRoutingChange(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	servers := []string{"Admin", "App-Services", "Manage"}
+
+	// enabling path based routing switches the default App Servers to basic authentication
+	fake := newFakeMarkLogic(t)
+	old := map[string]string{"PATH_BASED_ROUTING": "false"}
+	current := map[string]string{"PATH_BASED_ROUTING": "true"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=path_based_routing\n")
+	require.Len(t, fake.ModifyingRequests(), len(servers))
+	for _, server := range servers {
+		requests := fake.RequestsTo(http.MethodPut, "/manage/v2/servers/"+server+"/properties")
+		require.Len(t, requests, 1)
+		require.Equal(t, "Default", requests[0].Query.Get("group-id"))
+		require.JSONEq(t, `{"authentication":"basic"}`, requests[0].Body)
+	}
+
+	// disabling it switches them back to digest authentication
+	fake = newFakeMarkLogic(t)
+	output, err = testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, current, old), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=path_based_routing\n")
+	for _, server := range servers {
+		requests := fake.RequestsTo(http.MethodPut, "/manage/v2/servers/"+server+"/properties")
+		require.Len(t, requests, 1)
+		require.JSONEq(t, `{"authentication":"digest"}`, requests[0].Body)
+	}
+}
+
+func TestReconcileConvertersChange(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	old := map[string]string{"INSTALL_CONVERTERS": "false"}
+	current := map[string]string{"INSTALL_CONVERTERS": "true"}
+
+	// converters installed by the image entrypoint
+	env := reconcileEnv(fake, old, current)
+	env["CONVERTERS_PATH"] = t.TempDir()
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=install_converters\n")
+	require.Empty(t, fake.ModifyingRequests())
+
+	// converters missing although requested
+	env["CONVERTERS_PATH"] = filepath.Join(t.TempDir(), "missing")
+	output, err = testUtil.RunHelmScript(t, scriptsDir, env, reconcileScript)
+	require.Error(t, err)
+	require.Contains(t, output, "converters are not installed")
+	require.Contains(t, output, "RECONCILED=\n")
+}
+
+func TestReconcileOnlyChangedSettings(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	old := map[string]string{"REALM": "public", "LICENSE_KEY": "key", "LICENSEE": "licensee", "PATH_BASED_ROUTING": "true"}
+	current := map[string]string{"REALM": "marklogic", "LICENSE_KEY": "new-key"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=license realm\n")
+	require.Len(t, fake.RequestsTo(http.MethodPost, "/admin/v1/init"), 1)
+	require.Len(t, fake.RequestsTo(http.MethodPost, "/v1/eval"), 1)
+	require.Len(t, fake.ModifyingRequests(), 2)
+}
+
+func TestReconcileNonBootstrapHost(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	// settings of the cluster are left to the bootstrap host
+	old := map[string]string{"REALM": "public", "LICENSE_KEY": "key", "LICENSEE": "licensee"}
+	current := map[string]string{"REALM": "marklogic", "LICENSE_KEY": "new-key", "CLUSTER_TYPE": "non-bootstrap"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "realm changed, reconciled by the bootstrap host")
+	require.Contains(t, output, "RECONCILED=license\n")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/v1/eval"))
+}
+
+func TestReconcileFailure(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPut, "/manage/v2/servers/Admin/properties", http.StatusBadRequest, "")
+
+	old := map[string]string{"PATH_BASED_ROUTING": "false", "LICENSE_KEY": "key"}
+	current := map[string]string{"PATH_BASED_ROUTING": "true", "LICENSE_KEY": "new-key"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), reconcileScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to set basic authentication on Admin, response code: 400")
+	// the other changed settings are still reapplied
+	require.Contains(t, output, "RECONCILED=license\n")
+}
+
+func TestReconcileFailureRetriedOnRestart(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPut, "/manage/v2/servers/Admin/properties", http.StatusBadRequest, "")
+
+	// the next status file records the reapplied license but not the failed setting
+	script := reconcileScript[:strings.LastIndex(reconcileScript, "exit")] + `
+write_config_hashes "${status_file}.next" "${status_file}"
+echo "PENDING=${PENDING_SETTINGS[*]}"
+echo "CHANGED=$(changed_settings "${status_file}.next" | tr '\n' ' ')"
+`
+	old := map[string]string{"PATH_BASED_ROUTING": "false", "LICENSE_KEY": "key"}
+	current := map[string]string{"PATH_BASED_ROUTING": "true", "LICENSE_KEY": "new-key"}
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, old, current), script)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=license\n")
+	require.Contains(t, output, "PENDING=path_based_routing\n")
+	require.Contains(t, output, "CHANGED=path_based_routing \n")
+}
+
+func TestPendingSettingNotRecorded(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+
+	// a setting whose phase did not succeed during the bootstrap is applied on restart
+	script := `
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+setting_pending path_based_routing
+write_config_hashes "${status_file}" "${status_file}"
+echo "CHANGED=$(changed_settings "${status_file}" | tr '\n' ' ')"
+setting_applied path_based_routing
+write_config_hashes "${status_file}.next" "${status_file}"
+echo "APPLIED=$(changed_settings "${status_file}.next" | tr '\n' ' ')"
+`
+	output, err := testUtil.RunHelmScript(t, scriptsDir, reconcileEnv(fake, nil, map[string]string{"PATH_BASED_ROUTING": "true"}), script)
+	require.NoError(t, err)
+	require.Contains(t, output, "CHANGED=path_based_routing \n")
+	require.Contains(t, output, "APPLIED=\n")
+	require.Empty(t, fake.Requests())
+}
+
+func TestReconcileLegacyStatusFile(t *testing.T) {
+	scriptsDir := renderSecurityScripts(t)
+	fake := newFakeSecurityAPI(t)
+
+	// a status file written before the configuration hashes were recorded applied none of the reconciled settings,
+	// each setting with a value is applied once, then recorded
+	script := `
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+printf 'fqdn=host\ngroup_name=Default\ngroup_xdqp_ssl_enabled=true\nhttps_enabled=false\n' > "${status_file}"
+reconcile_settings "${status_file}" bootstrap || exit 1
+echo "RECONCILED=${RECONCILED[*]}"
+write_config_hashes "${status_file}.next" "${status_file}"
+reconcile_settings "${status_file}.next" bootstrap
+`
+	env := securityEnv(t, fake, map[string]string{"app-service": "secret"})
+	env["REALM"] = "marklogic"
+	env["PATH_BASED_ROUTING"] = "true"
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=license realm path_based_routing roles_users install_converters\n")
+	require.Len(t, fake.RequestsTo(http.MethodPost, "/manage/v2/roles"), 2)
+	require.Len(t, fake.RequestsTo(http.MethodPost, "/manage/v2/users"), 2)
+	require.Len(t, fake.RequestsTo(http.MethodPut, "/manage/v2/servers/Admin/properties"), 1)
+
+	// the settings without a value are not applied, and the next reconcile finds nothing to do
+	require.NotContains(t, output, "external_security changed")
+	require.NotContains(t, output, "app_server_tls changed")
+	require.NotContains(t, output, "xdqp_certificate changed")
+	require.Contains(t, output, "no configuration drift detected")
+}
@@ -0,0 +1,69 @@
+package scripts_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const removeStaleHostScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+remove_stale_host "${EVAL_URL}"
+`
+
+func TestRemoveStaleHost(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	tests := map[string]struct {
+		result         string
+		expectedOutput string
+		expectedError  string
+	}{
+		"new host":    {"unknown-host", "is not a host of the cluster yet", ""},
+		"stale host":  {"removed", "removed the stale entry of marklogic-0.marklogic.default.svc.cluster.local from the cluster", ""},
+		"forests":     {"forests:Security,Schemas", "", "lost its data directory while the forests Security,Schemas are assigned to it"},
+		"unreachable": {"", "", "Failed to read the hosts of the cluster, response code: 200"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeMarkLogic(t)
+			fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, evalResponse(tc.result))
+			env := reconcileEnv(fake, nil, map[string]string{"HOST_FQDN": "marklogic-0.marklogic.default.svc.cluster.local"})
+
+			output, err := testUtil.RunHelmScript(t, scriptsDir, env, removeStaleHostScript)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				require.Contains(t, output, tc.expectedOutput)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, output, tc.expectedError)
+			}
+
+			eval := fake.RequestsTo(http.MethodPost, "/v1/eval")
+			require.Len(t, eval, 1)
+			form, err := url.ParseQuery(eval[0].Body)
+			require.NoError(t, err)
+			require.Contains(t, form.Get("xquery"), "admin:host-delete($config, $id)")
+			vars := map[string]string{}
+			require.NoError(t, json.Unmarshal([]byte(form.Get("vars")), &vars))
+			require.Equal(t, map[string]string{"host": "marklogic-0.marklogic.default.svc.cluster.local"}, vars)
+		})
+	}
+}
+
+func TestRemoveStaleHostKeptConfiguration(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	// the host joined a cluster before, its timestamp requires credentials
+	fake.Respond(http.MethodGet, "/admin/v1/timestamp", http.StatusUnauthorized, "")
+	env := reconcileEnv(fake, nil, map[string]string{"HOST_FQDN": "marklogic-0.marklogic.default.svc.cluster.local"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, removeStaleHostScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "kept its configuration, nothing to recover")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/v1/eval"))
+}
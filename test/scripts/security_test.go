@@ -0,0 +1,213 @@
+package scripts_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const rolesUsersScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+reconcile_roles_users
+`
+
+// renderSecurityScripts renders the chart scripts with the role app-writer, inheriting app-reader defined after it,
+// and the users app-service, with a password from a secret, and auditor, without password
+func renderSecurityScripts(t *testing.T) string {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"security.roles[0].name":                      "app-writer",
+			"security.roles[0].description":               "Writes the documents of the app",
+			"security.roles[0].roles[0]":                  "app-reader",
+			"security.roles[0].roles[1]":                  "rest-writer",
+			"security.roles[0].permissions[0].role":       "app-reader",
+			"security.roles[0].permissions[0].capability": "read",
+			"security.roles[0].permissions[1].role":       "app-writer",
+			"security.roles[0].permissions[1].capability": "update",
+			"security.roles[0].collections[0]":            "app",
+			"security.roles[1].name":                      "app-reader",
+			"security.roles[1].description":               "Reads the documents of the app",
+			"security.roles[1].roles[0]":                  "rest-reader",
+			"security.roles[1].privileges[0].name":        "xdmp:eval",
+			"security.roles[1].privileges[0].action":      "http://marklogic.com/xdmp/privileges/xdmp-eval",
+			"security.roles[1].privileges[1].name":        "app-docs",
+			"security.roles[1].privileges[1].action":      "/app/",
+			"security.roles[1].privileges[1].kind":        "uri",
+			"security.users[0].name":                      "app-service",
+			"security.users[0].description":               "Service account",
+			"security.users[0].passwordSecret.name":       "app-service-password",
+			"security.users[0].roles[0]":                  "app-writer",
+			"security.users[0].roles[1]":                  "rest-reader",
+			"security.users[0].permissions[0].role":       "app-reader",
+			"security.users[0].permissions[0].capability": "read",
+			"security.users[0].collections[0]":            "app",
+			"security.users[1].name":                      "auditor",
+			"security.users[1].roles[0]":                  "app-reader",
+		},
+	}
+	return testUtil.RenderHelmScripts(t, options, helmChartPath, "security")
+}
+
+// newFakeSecurityAPI returns a fake Manage API where the roles and users created with POST
+// can then be read and updated through their properties
+func newFakeSecurityAPI(t *testing.T) *testUtil.FakeManageAPI {
+	fake := newFakeMarkLogic(t)
+	for collection, nameField := range map[string]string{"roles": "role-name", "users": "user-name"} {
+		collection, nameField := collection, nameField
+		fake.Handle(http.MethodPost, "/manage/v2/"+collection, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &payload))
+			fake.SetResource("/manage/v2/"+collection+"/"+payload[nameField].(string)+"/properties", string(body))
+			w.WriteHeader(http.StatusCreated)
+		})
+	}
+	return fake
+}
+
+func securityEnv(t *testing.T, fake *testUtil.FakeManageAPI, passwords map[string]string) map[string]string {
+	passwordsDir := t.TempDir()
+	writeSecret(t, passwordsDir, passwords)
+	return map[string]string{
+		"MARKLOGIC_ADMIN_USERNAME": "admin",
+		"MARKLOGIC_ADMIN_PASSWORD": "admin",
+		"MARKLOGIC_GROUP":          "Default",
+		"ADMIN_URL":                fake.URL(),
+		"MANAGE_URL":               fake.URL(),
+		"EVAL_URL":                 fake.URL(),
+		"USER_PASSWORDS_PATH":      passwordsDir,
+	}
+}
+
+func TestRolesAndUsersCreated(t *testing.T) {
+	scriptsDir := renderSecurityScripts(t)
+	fake := newFakeSecurityAPI(t)
+	env := securityEnv(t, fake, map[string]string{"app-service": `s3"cr\et`})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, rolesUsersScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "2 roles and 2 users configured")
+
+	// both roles are created by name before app-writer, which inherits app-reader, is given its properties
+	created := fake.RequestsTo(http.MethodPost, "/manage/v2/roles")
+	require.Len(t, created, 2)
+	require.JSONEq(t, `{"role-name":"app-writer"}`, created[0].Body)
+	require.JSONEq(t, `{"role-name":"app-reader"}`, created[1].Body)
+	writer := fake.RequestsTo(http.MethodPut, "/manage/v2/roles/app-writer/properties")
+	require.Len(t, writer, 1)
+	require.JSONEq(t, `{
+		"role-name": "app-writer",
+		"description": "Writes the documents of the app",
+		"role": ["app-reader", "rest-writer"],
+		"privilege": [],
+		"permission": [
+			{"role-name": "app-reader", "capability": "read"},
+			{"role-name": "app-writer", "capability": "update"}
+		],
+		"collection": ["app"]
+	}`, writer[0].Body)
+	reader, _ := fake.Resource("/manage/v2/roles/app-reader/properties")
+	require.JSONEq(t, `{
+		"role-name": "app-reader",
+		"description": "Reads the documents of the app",
+		"role": ["rest-reader"],
+		"privilege": [
+			{"privilege-name": "xdmp:eval", "action": "http://marklogic.com/xdmp/privileges/xdmp-eval", "kind": "execute"},
+			{"privilege-name": "app-docs", "action": "/app/", "kind": "uri"}
+		],
+		"permission": [],
+		"collection": []
+	}`, reader)
+
+	// users are created with the password from the secret, escaped in the payload
+	users := fake.RequestsTo(http.MethodPost, "/manage/v2/users")
+	require.Len(t, users, 2)
+	require.JSONEq(t, `{
+		"user-name": "app-service",
+		"description": "Service account",
+		"password": "s3\"cr\\et",
+		"role": ["app-writer", "rest-reader"],
+		"permission": [{"role-name": "app-reader", "capability": "read"}],
+		"collection": ["app"]
+	}`, users[0].Body)
+	require.NotContains(t, users[1].Body, "password")
+}
+
+func TestRolesAndUsersUpdated(t *testing.T) {
+	scriptsDir := renderSecurityScripts(t)
+	fake := newFakeSecurityAPI(t)
+	fake.SetResource("/manage/v2/roles/app-writer/properties", `{"role-name":"app-writer","role":["rest-writer"]}`)
+	fake.SetResource("/manage/v2/roles/app-reader/properties", `{"role-name":"app-reader"}`)
+	fake.SetResource("/manage/v2/users/app-service/properties", `{"user-name":"app-service"}`)
+	fake.SetResource("/manage/v2/users/auditor/properties", `{"user-name":"auditor"}`)
+	env := securityEnv(t, fake, map[string]string{"app-service": "secret"})
+
+	_, err := testUtil.RunHelmScript(t, scriptsDir, env, rolesUsersScript)
+	require.NoError(t, err)
+
+	// existing roles and users are replaced with the values, nothing is created
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/manage/v2/roles"))
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/manage/v2/users"))
+	writer, _ := fake.Resource("/manage/v2/roles/app-writer/properties")
+	require.Contains(t, writer, `"role":["app-reader","rest-writer"]`)
+	user := fake.RequestsTo(http.MethodPut, "/manage/v2/users/app-service/properties")
+	require.Len(t, user, 1)
+	require.Contains(t, user[0].Body, `"password":"secret"`)
+	require.Len(t, fake.ModifyingRequests(), 4)
+}
+
+func TestRolesAndUsersFailure(t *testing.T) {
+	scriptsDir := renderSecurityScripts(t)
+	fake := newFakeSecurityAPI(t)
+	fake.Respond(http.MethodPut, "/manage/v2/roles/app-writer/properties", http.StatusBadRequest, "")
+	env := securityEnv(t, fake, map[string]string{"app-service": "secret"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, rolesUsersScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to configure role app-writer, response code: 400")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/manage/v2/users"))
+}
+
+func TestRolesAndUsersReconciledWhenPasswordChanges(t *testing.T) {
+	scriptsDir := renderSecurityScripts(t)
+	fake := newFakeSecurityAPI(t)
+	env := securityEnv(t, fake, map[string]string{"app-service": "old"})
+
+	// the configuration is recorded with the old password, then the secret is rotated
+	script := `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+write_config_hashes "${status_file}"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}" || exit 1
+echo -n "new" > "${USER_PASSWORDS_PATH}/app-service"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}"
+rc=$?
+echo "RECONCILED=${RECONCILED[*]}"
+exit ${rc}
+`
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=roles_users\n")
+	created := fake.RequestsTo(http.MethodPost, "/manage/v2/users")
+	require.Len(t, created, 2)
+	require.Contains(t, created[0].Body, `"password":"new"`)
+
+	// hosts other than the bootstrap host leave the roles and users to it
+	fake = newFakeSecurityAPI(t)
+	env = securityEnv(t, fake, map[string]string{"app-service": "old"})
+	env["CLUSTER_TYPE"] = "non-bootstrap"
+	output, err = testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "roles_users changed, reconciled by the bootstrap host")
+	require.Empty(t, fake.ModifyingRequests())
+}
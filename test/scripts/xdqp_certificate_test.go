@@ -0,0 +1,210 @@
+package scripts_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+	"github.com/stretchr/testify/require"
+)
+
+const xdqpCertificateScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+reconcile_xdqp_certificate
+`
+
+const checkXdqpCertificateScript = `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+check_xdqp_certificate "${EVAL_URL}"
+`
+
+// evalResponse is the multipart response of /v1/eval returning a single value
+func evalResponse(value string) string {
+	return strings.Join([]string{
+		"--BOUNDARY",
+		"Content-Type: text/plain",
+		"X-Primitive: string",
+		"",
+		value,
+		"--BOUNDARY--",
+		"",
+	}, "\r\n")
+}
+
+// xdqpCertificateEnv mounts a secret with the XDQP certificate of the cluster issued by a test CA
+func xdqpCertificateEnv(t *testing.T, fake *testUtil.FakeManageAPI) (map[string]string, testUtil.Certificate) {
+	ca := testUtil.NewCertificateAuthority(t, "xdqp-ca")
+	certificate := ca.Issue(t, "marklogic", "*.marklogic.default.svc.cluster.local")
+	env := reconcileEnv(fake, nil, nil)
+	env["XDQP_CERT_PATH"] = t.TempDir()
+	writeSecret(t, env["XDQP_CERT_PATH"], map[string]string{
+		"tls.crt":    string(certificate.CertPEM),
+		"tls.key":    string(certificate.KeyPEM),
+		"cacert.pem": string(ca.CertPEM),
+	})
+	return env, certificate
+}
+
+func TestXdqpCertificateSet(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	// one host restarts to use the new certificate
+	fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, strings.Replace(evalResponse("1"), "string", "integer", 1))
+	fake.RestartOn(http.MethodPost, "/v1/eval")
+	env, certificate := xdqpCertificateEnv(t, fake)
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, xdqpCertificateScript)
+	require.NoError(t, err)
+	require.Contains(t, output, "XDQP certificate of the cluster set")
+	require.Contains(t, output, "MarkLogic has restarted.")
+	// the baseline startup time is read before the change
+	require.Equal(t, http.MethodGet, fake.Requests()[0].Method)
+	require.Equal(t, "/admin/v1/timestamp", fake.Requests()[0].Path)
+
+	eval := fake.RequestsTo(http.MethodPost, "/v1/eval")
+	require.Len(t, eval, 1)
+	form, err := url.ParseQuery(eval[0].Body)
+	require.NoError(t, err)
+	require.Contains(t, form.Get("xquery"), "pki:insert-trusted-certificates($ca)")
+	require.Contains(t, form.Get("xquery"), "admin:cluster-set-xdqp-ssl-certificate(admin:get-configuration(), $cert)")
+	require.Contains(t, form.Get("xquery"), "admin:cluster-set-xdqp-ssl-private-key($config, $key)")
+	vars := map[string]string{}
+	require.NoError(t, json.Unmarshal([]byte(form.Get("vars")), &vars))
+	require.Equal(t, strings.TrimSpace(string(certificate.CertPEM)), vars["cert"])
+	require.Equal(t, strings.TrimSpace(string(certificate.KeyPEM)), vars["key"])
+	require.Contains(t, vars["ca"], "-----BEGIN CERTIFICATE-----")
+}
+
+func TestXdqpCertificateWaitsForRestart(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	// the host reports the startup time before the change, it has not restarted yet
+	fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, strings.Replace(evalResponse("1"), "string", "integer", 1))
+	env, _ := xdqpCertificateEnv(t, fake)
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, xdqpCertificateScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to restart localhost")
+	require.NotContains(t, output, "XDQP certificate of the cluster set")
+}
+
+func TestXdqpCertificateWithoutStartupTime(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodGet, "/admin/v1/timestamp", http.StatusUnauthorized, "<error>401 Unauthorized</error>")
+	env, _ := xdqpCertificateEnv(t, fake)
+
+	// without a baseline the restart could not be detected, the certificate is not changed
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, xdqpCertificateScript)
+	require.Error(t, err)
+	require.Contains(t, output, "Failed to read the startup time of MarkLogic before setting the XDQP certificate")
+	require.Empty(t, fake.RequestsTo(http.MethodPost, "/v1/eval"))
+}
+
+func TestXdqpCertificateUnchanged(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, strings.Replace(evalResponse("0"), "string", "integer", 1))
+	env, _ := xdqpCertificateEnv(t, fake)
+
+	// the certificate was already set, no host restarts
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, xdqpCertificateScript)
+	require.NoError(t, err)
+	require.NotContains(t, output, "Waiting for MarkLogic to restart.")
+}
+
+func TestXdqpCertificateIncompleteSecret(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	env := reconcileEnv(fake, nil, nil)
+	env["XDQP_CERT_PATH"] = t.TempDir()
+	writeSecret(t, env["XDQP_CERT_PATH"], map[string]string{"tls.crt": "certificate"})
+
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, xdqpCertificateScript)
+	require.Error(t, err)
+	require.Contains(t, output, "The XDQP certificate secret must contain tls.crt, tls.key and cacert.pem")
+	require.Empty(t, fake.Requests())
+}
+
+func TestXdqpCertificateCheckBeforeJoin(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	tests := map[string]struct {
+		result        string
+		expectedError string
+	}{
+		"compatible":  {"compatible", ""},
+		"mismatch":    {"certificate-mismatch", "uses another XDQP certificate than the secret of group.xdqpSsl.certSecretName"},
+		"groups":      {"xdqp-ssl-disabled:enode,jobs", "The groups enode,jobs of the cluster have XDQP SSL disabled"},
+		"unreachable": {"", "Failed to read the XDQP configuration of the cluster, response code: 200"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeMarkLogic(t)
+			fake.Respond(http.MethodPost, "/v1/eval", http.StatusOK, evalResponse(tc.result))
+			env, certificate := xdqpCertificateEnv(t, fake)
+			env["MARKLOGIC_BOOTSTRAP_HOST"] = "dnode-0.dnode.default.svc.cluster.local"
+
+			output, err := testUtil.RunHelmScript(t, scriptsDir, env, checkXdqpCertificateScript)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				require.Contains(t, output, "the XDQP configuration is compatible with the cluster")
+			} else {
+				require.Error(t, err)
+				require.Contains(t, output, tc.expectedError)
+			}
+
+			// only the certificate is sent to the bootstrap host, never its private key
+			eval := fake.RequestsTo(http.MethodPost, "/v1/eval")
+			require.Len(t, eval, 1)
+			form, err := url.ParseQuery(eval[0].Body)
+			require.NoError(t, err)
+			require.Contains(t, form.Get("xquery"), "admin:cluster-get-xdqp-ssl-certificate($config)")
+			vars := map[string]string{}
+			require.NoError(t, json.Unmarshal([]byte(form.Get("vars")), &vars))
+			require.Equal(t, map[string]string{"cert": strings.TrimSpace(string(certificate.CertPEM))}, vars)
+		})
+	}
+}
+
+func TestXdqpCertificateReconciledWhenSecretChanges(t *testing.T) {
+	scriptsDir := renderScripts(t)
+	fake := newFakeMarkLogic(t)
+	env, _ := xdqpCertificateEnv(t, fake)
+	renewed := testUtil.NewCertificateAuthority(t, "xdqp-ca").Issue(t, "marklogic", "*.marklogic.default.svc.cluster.local")
+	env["RENEWED_CERT"] = string(renewed.CertPEM)
+
+	// the configuration is recorded with the first certificate, then the certificate is renewed
+	script := `
+source "${HELM_SCRIPTS_PATH}/bootstrap-status.sh"
+source "${HELM_SCRIPTS_PATH}/reconcile.sh"
+status_file="${ML_KUBERNETES_FILE_PATH}/status.txt"
+write_config_hashes "${status_file}"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}" || exit 1
+echo -n "${RENEWED_CERT}" > "${XDQP_CERT_PATH}/tls.crt"
+reconcile_settings "${status_file}" "${CLUSTER_TYPE:-bootstrap}"
+rc=$?
+echo "RECONCILED=${RECONCILED[*]}"
+exit ${rc}
+`
+	output, err := testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "RECONCILED=xdqp_certificate\n")
+	eval := fake.RequestsTo(http.MethodPost, "/v1/eval")
+	require.Len(t, eval, 1)
+	require.Contains(t, eval[0].Body, url.QueryEscape("-----BEGIN CERTIFICATE-----"))
+
+	// hosts other than the bootstrap host leave the certificate of the cluster to it
+	fake = newFakeMarkLogic(t)
+	env, _ = xdqpCertificateEnv(t, fake)
+	env["RENEWED_CERT"] = string(renewed.CertPEM)
+	env["CLUSTER_TYPE"] = "non-bootstrap"
+	output, err = testUtil.RunHelmScript(t, scriptsDir, env, script)
+	require.NoError(t, err)
+	require.Contains(t, output, "xdqp_certificate changed, reconciled by the bootstrap host")
+	require.Empty(t, fake.ModifyingRequests())
+}
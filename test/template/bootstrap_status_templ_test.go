@@ -0,0 +1,116 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplateBootstrapStatusEnabled(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "bootstrap-status"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":    "progressofficial/marklogic-db",
+			"image.tag":           "latest",
+			"persistence.enabled": "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/rbac.yaml"})
+	docs := strings.Split(output, "\n---\n")
+	require.Len(t, docs, 2)
+
+	// Verify the role allows reporting events, the annotation and the pod condition
+	var role rbacv1.Role
+	helm.UnmarshalK8SYaml(t, docs[0], &role)
+	require.Equal(t, releaseName+"-bootstrap-status", role.Name)
+	require.Equal(t, namespaceName, role.Namespace)
+	require.Len(t, role.Rules, 3)
+	require.Equal(t, []string{"events"}, role.Rules[0].Resources)
+	require.Equal(t, []string{"create"}, role.Rules[0].Verbs)
+	require.Equal(t, []string{"pods"}, role.Rules[1].Resources)
+	require.Equal(t, []string{"get", "patch"}, role.Rules[1].Verbs)
+	require.Equal(t, []string{"pods/status"}, role.Rules[2].Resources)
+	require.Equal(t, []string{"patch"}, role.Rules[2].Verbs)
+
+	// Verify the role is bound to the service account of the pods
+	var roleBinding rbacv1.RoleBinding
+	helm.UnmarshalK8SYaml(t, docs[1], &roleBinding)
+	require.Equal(t, role.Name, roleBinding.RoleRef.Name)
+	require.Equal(t, "ServiceAccount", roleBinding.Subjects[0].Kind)
+	require.Equal(t, releaseName, roleBinding.Subjects[0].Name)
+
+	// Verify the poststart hook is told to report the bootstrap status
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, strings.Split(output, "\n---\n")[0], &configmap)
+	require.Equal(t, "true", configmap.Data["MARKLOGIC_BOOTSTRAP_STATUS_ENABLED"])
+
+	// Verify the pod namespace and uid are available to the poststart hook
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	fieldPaths := map[string]string{}
+	for _, env := range statefulset.Spec.Template.Spec.Containers[0].Env {
+		if env.ValueFrom != nil && env.ValueFrom.FieldRef != nil {
+			fieldPaths[env.Name] = env.ValueFrom.FieldRef.FieldPath
+		}
+	}
+	require.Equal(t, "metadata.namespace", fieldPaths["POD_NAMESPACE"])
+	require.Equal(t, "metadata.uid", fieldPaths["POD_UID"])
+}
+
+func TestChartTemplateBootstrapStatusDisabled(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "bootstrap-status"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+
+	// no RBAC is rendered when the status is not reported to Kubernetes
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"bootstrapStatus.enabled": "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/rbac.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not find template")
+
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, strings.Split(output, "\n---\n")[0], &configmap)
+	require.Equal(t, "false", configmap.Data["MARKLOGIC_BOOTSTRAP_STATUS_ENABLED"])
+
+	// no RBAC is rendered when the permissions are granted by other means
+	options.SetValues = map[string]string{
+		"bootstrapStatus.rbac.create": "false",
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/rbac.yaml"})
+	require.Error(t, err)
+}
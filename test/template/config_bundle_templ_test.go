@@ -0,0 +1,177 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplateConfigBundleFromConfigMaps(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "bundle"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":             "false",
+			"configBundle.enabled":            "true",
+			"configBundle.image.repository":   "registry.example.com/mlconfig",
+			"configBundle.image.tag":          "1.0.0",
+			"configBundle.dryRun":             "true",
+			"configBundle.configMaps[0].name": "ml-roles",
+			"configBundle.configMaps[0].path": "security/roles",
+			"configBundle.configMaps[1].name": "ml-databases",
+			"configBundle.configMaps[1].path": "/databases/",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/job-config-bundle.yaml"})
+	var job batchv1.Job
+	helm.UnmarshalK8SYaml(t, output, &job)
+
+	// Verify the Job runs after every install and upgrade
+	require.Equal(t, releaseName+"-config-bundle", job.Name)
+	require.Equal(t, "post-install,post-upgrade", job.Annotations["helm.sh/hook"])
+	require.Equal(t, "before-hook-creation", job.Annotations["helm.sh/hook-delete-policy"])
+
+	// Verify the Job pod is not selected by the MarkLogic services
+	require.NotEqual(t, "marklogic", job.Spec.Template.Labels["app.kubernetes.io/name"])
+
+	container := job.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "registry.example.com/mlconfig:1.0.0", container.Image)
+	require.Contains(t, container.Args, "-manage-url=http://bundle-0.bundle."+namespaceName+".svc.cluster.local:8002")
+	require.Contains(t, container.Args, "-dry-run")
+	require.Contains(t, container.Args, "-password-file=/run/secrets/ml-secrets/password")
+
+	// Verify each ConfigMap is mounted at its directory of the layout
+	mounts := map[string]string{}
+	for _, mount := range container.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	require.Equal(t, "/ml-config/security/roles", mounts["config-bundle-0"])
+	require.Equal(t, "/ml-config/databases", mounts["config-bundle-1"])
+	require.Equal(t, "/run/secrets/ml-secrets", mounts["mladmin-secrets"])
+	volumes := map[string]corev1.Volume{}
+	for _, volume := range job.Spec.Template.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+	require.Equal(t, "ml-roles", volumes["config-bundle-0"].ConfigMap.Name)
+	require.Equal(t, "ml-databases", volumes["config-bundle-1"].ConfigMap.Name)
+	require.Equal(t, releaseName+"-admin", volumes["mladmin-secrets"].Secret.SecretName)
+}
+
+func TestChartTemplateConfigBundleFromVolumeWithTLS(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "bundle"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":                                 "false",
+			"tls.enableOnDefaultAppServers":                       "true",
+			"tls.caSecretName":                                    "ca-cert",
+			"configBundle.enabled":                                "true",
+			"configBundle.image.repository":                       "registry.example.com/mlconfig",
+			"configBundle.image.tag":                              "1.0.0",
+			"configBundle.volume.persistentVolumeClaim.claimName": "ml-config",
+			"configBundle.subPath":                                "src/main/ml-config",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/job-config-bundle.yaml"})
+	var job batchv1.Job
+	helm.UnmarshalK8SYaml(t, output, &job)
+
+	// Verify the Job uses HTTPS and verifies the App Servers with the CA
+	container := job.Spec.Template.Spec.Containers[0]
+	require.Contains(t, container.Args, "-manage-url=https://bundle-0.bundle."+namespaceName+".svc.cluster.local:8002")
+	require.Contains(t, container.Args, "-ca-file=/run/secrets/marklogic-ca/cacert.pem")
+	require.NotContains(t, container.Args, "-insecure")
+	require.NotContains(t, container.Args, "-dry-run")
+
+	found := false
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == "config-bundle" {
+			found = true
+			require.Equal(t, "/ml-config", mount.MountPath)
+			require.Equal(t, "src/main/ml-config", mount.SubPath)
+		}
+	}
+	require.True(t, found)
+	for _, volume := range job.Spec.Template.Spec.Volumes {
+		if volume.Name == "config-bundle" {
+			require.Equal(t, "ml-config", volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+}
+
+func TestChartTemplateConfigBundleValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "bundle"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"missing image": {map[string]string{
+			"configBundle.configMaps[0].name": "ml-roles",
+			"configBundle.configMaps[0].path": "security/roles",
+		}, "configBundle.image.repository and configBundle.image.tag must be set"},
+		"missing bundle": {map[string]string{
+			"configBundle.image.repository": "registry.example.com/mlconfig",
+			"configBundle.image.tag":        "1.0.0",
+		}, "configBundle requires configMaps or a volume holding the bundle"},
+		"duplicate path": {map[string]string{
+			"configBundle.image.repository":   "registry.example.com/mlconfig",
+			"configBundle.image.tag":          "1.0.0",
+			"configBundle.configMaps[0].name": "ml-roles",
+			"configBundle.configMaps[0].path": "security/roles",
+			"configBundle.configMaps[1].name": "more-roles",
+			"configBundle.configMaps[1].path": "security/roles/",
+		}, `The configBundle.configMaps path "security/roles/" is used more than once`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.values["configBundle.enabled"] = "true"
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/job-config-bundle.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+
+	// no Job is rendered unless enabled
+	options := &helm.Options{KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName)}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/job-config-bundle.yaml"})
+	require.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 
 	"github.com/gruntwork-io/terratest/modules/helm"
 	"github.com/gruntwork-io/terratest/modules/k8s"
@@ -123,11 +124,20 @@ func TestChartTemplateLicenseValues(t *testing.T) {
 	// Verify the name and namespace matches
 	require.Equal(t, namespaceName, statefulset.Namespace)
 
-	// Verify the value of licenseKey and licensee parameters
+	// Verify the license is not passed in environment variables
+	for _, env := range statefulset.Spec.Template.Spec.Containers[0].Env {
+		require.NotEqual(t, "LICENSE_KEY", env.Name)
+		require.NotEqual(t, "LICENSEE", env.Name)
+	}
+
+	// Verify the value of licenseKey and licensee parameters are stored in the license secret
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/license-secret.yaml"})
+	var secret corev1.Secret
+	helm.UnmarshalK8SYaml(t, output, &secret)
 	expectedLicenseKey := "Test License Key"
 	expectedLicensee := "Test Licensee"
-	actualLicenseKey := statefulset.Spec.Template.Spec.Containers[0].Env[4].Value
-	actualLicensee := statefulset.Spec.Template.Spec.Containers[0].Env[5].Value
+	actualLicenseKey := string(secret.Data["license-key"])
+	actualLicensee := string(secret.Data["licensee"])
 
 	require.Equal(t, actualLicenseKey, expectedLicenseKey)
 	require.Equal(t, actualLicensee, expectedLicensee)
@@ -167,6 +177,6 @@ func TestChartTemplateAuthRealmValue(t *testing.T) {
 
 	// Verify the value of security realm
 	expectedRealm := "public"
-	actualRealm := statefulset.Spec.Template.Spec.Containers[0].Env[6].Value
+	actualRealm := statefulset.Spec.Template.Spec.Containers[0].Env[4].Value
 	require.Equal(t, actualRealm, expectedRealm)
 }
@@ -0,0 +1,168 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplateExternalSecurity(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ldap"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":                              "false",
+			"group.name":                                       "dnode",
+			"externalSecurity.enabled":                         "true",
+			"externalSecurity.name":                            "corp-ldap",
+			"externalSecurity.ldap.serverURI":                  "ldaps://ldap.example.com:636",
+			"externalSecurity.ldap.base":                       "ou=people\\,dc=example\\,dc=com",
+			"externalSecurity.ldap.memberOfAttribute":          "memberOf",
+			"externalSecurity.ldap.bindSecretName":             "ldap-bind",
+			"externalSecurity.roleMapping[0].role":             "app-reader",
+			"externalSecurity.roleMapping[0].externalNames[0]": "cn=readers\\,ou=groups\\,dc=example\\,dc=com",
+			"externalSecurity.roleMapping[0].externalNames[1]": "cn=auditors\\,ou=groups\\,dc=example\\,dc=com",
+			"externalSecurity.appServers[0].name":              "App-Services",
+			"externalSecurity.appServers[1].name":              "app",
+			"externalSecurity.appServers[1].group":             "enode",
+			"externalSecurity.appServers[1].internalSecurity":  "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+
+	// Verify the external security payload, without bind credentials
+	require.JSONEq(t, `{
+		"external-security-name": "corp-ldap",
+		"description": "LDAP authentication",
+		"authentication": "ldap",
+		"authorization": "ldap",
+		"cache-timeout": 300,
+		"ldap-server": {
+			"ldap-server-uri": "ldaps://ldap.example.com:636",
+			"ldap-base": "ou=people,dc=example,dc=com",
+			"ldap-attribute": "uid",
+			"ldap-bind-method": "simple",
+			"ldap-memberof-attribute": "memberOf"
+		}
+	}`, configmap.Data["external-security.json"])
+
+	// Verify the role mapping and the App Servers
+	require.Equal(t, `app-reader|{"external-name":["cn=readers,ou=groups,dc=example,dc=com","cn=auditors,ou=groups,dc=example,dc=com"]}`+"\n",
+		configmap.Data["external-security-roles.conf"])
+	require.Equal(t, `App-Services|dnode|{"authentication":"basic","external-security":["corp-ldap"],"internal-security":true}`+"\n"+
+		`app|enode|{"authentication":"basic","external-security":["corp-ldap"],"internal-security":false}`+"\n",
+		configmap.Data["external-security-servers.conf"])
+
+	// Verify the bind secret is mounted into the MarkLogic container
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	found := false
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "ldap-bind" {
+			found = true
+			require.Equal(t, "ldap-bind", volume.Secret.SecretName)
+		}
+	}
+	require.True(t, found)
+	mounted := false
+	for _, mount := range statefulset.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "ldap-bind" {
+			mounted = true
+			require.Equal(t, "/run/secrets/ml-ldap-bind", mount.MountPath)
+			require.True(t, mount.ReadOnly)
+		}
+	}
+	require.True(t, mounted)
+}
+
+func TestChartTemplateNoExternalSecurity(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ldap"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	options := &helm.Options{
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Empty(t, configmap.Data["external-security.json"])
+	require.Empty(t, configmap.Data["external-security-roles.conf"])
+	require.Empty(t, configmap.Data["external-security-servers.conf"])
+}
+
+func TestChartTemplateExternalSecurityValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ldap"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	ldap := func(values map[string]string) map[string]string {
+		result := map[string]string{
+			"externalSecurity.enabled":        "true",
+			"externalSecurity.ldap.serverURI": "ldap://ldap.example.com:389",
+			"externalSecurity.ldap.base":      "dc=example\\,dc=com",
+		}
+		for key, value := range values {
+			result[key] = value
+		}
+		return result
+	}
+
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"invalid server URI":    {ldap(map[string]string{"externalSecurity.ldap.serverURI": "ldap.example.com"}), "externalSecurity.ldap.serverURI must be an ldap:// or ldaps:// URI"},
+		"missing base":          {ldap(map[string]string{"externalSecurity.ldap.base": ""}), "externalSecurity.ldap.base must be set"},
+		"invalid name":          {ldap(map[string]string{"externalSecurity.name": "corp ldap"}), `externalSecurity.name "corp ldap" is invalid`},
+		"unknown authorization": {ldap(map[string]string{"externalSecurity.authorization": "kerberos"}), `externalSecurity.authorization is "kerberos"`},
+		"role without names":    {ldap(map[string]string{"externalSecurity.roleMapping[0].role": "app-reader"}), "Each of externalSecurity.roleMapping must set role and externalNames"},
+		"digest App Server": {ldap(map[string]string{
+			"externalSecurity.appServers[0].name":           "App-Services",
+			"externalSecurity.appServers[0].authentication": "digest",
+		}), "The App Server App-Services can not use digest authentication with LDAP"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+const groupsValues = `[{"name":"enode","replicaCount":2,"enableXdqpSsl":false,"appServers":["appservices","admin","manage","dhf"],` +
+	`"resources":{"limits":{"memory":"8Gi"}},"persistence":{"size":"20Gi"}}]`
+
+func groupsOptions() *helm.Options {
+	return &helm.Options{
+		SetValues: map[string]string{
+			"group.name":                           "dnode",
+			"group.appServers[0]":                  "admin",
+			"group.appServers[1]":                  "manage",
+			"haproxy.enabled":                      "true",
+			"haproxy.additionalAppServers[0].name": "dhf",
+			"haproxy.additionalAppServers[0].type": "HTTP",
+			"haproxy.additionalAppServers[0].port": "8010",
+			"haproxy.additionalAppServers[0].path": "/dhf",
+		},
+		SetJsonValues: map[string]string{
+			"groups": groupsValues,
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+}
+
+func envValue(container corev1.Container, name string) string {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func TestChartTemplateGroupsStatefulSets(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ml"
+	require.NoError(t, err)
+
+	output := helm.RenderTemplate(t, groupsOptions(), helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	statefulsets := map[string]appsv1.StatefulSet{}
+	for _, doc := range strings.Split(output, "\n---\n") {
+		if !strings.Contains(doc, "kind: StatefulSet") {
+			continue
+		}
+		var statefulset appsv1.StatefulSet
+		helm.UnmarshalK8SYaml(t, doc, &statefulset)
+		statefulsets[statefulset.Name] = statefulset
+	}
+	require.Len(t, statefulsets, 2)
+
+	// Verify the group of group.name keeps the StatefulSet of the release
+	dnode := statefulsets["ml"]
+	require.EqualValues(t, 1, *dnode.Spec.Replicas)
+	require.Equal(t, "dnode", dnode.Spec.Selector.MatchLabels["marklogic.com/group"])
+	require.Equal(t, "dnode", dnode.Spec.Template.Labels["marklogic.com/group"])
+	dnodeContainer := dnode.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "dnode", envValue(dnodeContainer, "MARKLOGIC_GROUP"))
+	require.Equal(t, "", envValue(dnodeContainer, "MARKLOGIC_BOOTSTRAP_HOST"))
+
+	// Verify the additional group joins the cluster of the release with its own settings
+	enode := statefulsets["ml-enode"]
+	require.EqualValues(t, 2, *enode.Spec.Replicas)
+	require.Equal(t, "ml", enode.Spec.ServiceName)
+	require.Equal(t, "enode", enode.Spec.Selector.MatchLabels["marklogic.com/group"])
+	require.Equal(t, "enode", enode.Spec.Template.Labels["marklogic.com/group"])
+	enodeContainer := enode.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "enode", envValue(enodeContainer, "MARKLOGIC_GROUP"))
+	require.Equal(t, "non-bootstrap", envValue(enodeContainer, "MARKLOGIC_CLUSTER_TYPE"))
+	require.Equal(t, "ml-0.ml.marklogic-templ.svc.cluster.local", envValue(enodeContainer, "MARKLOGIC_BOOTSTRAP_HOST"))
+	require.Equal(t, "false", envValue(enodeContainer, "XDQP_SSL_ENABLED"))
+	require.Equal(t, "2", envValue(enodeContainer, "MARKLOGIC_REPLICAS"))
+	require.Equal(t, "8Gi", enodeContainer.Resources.Limits.Memory().String())
+	require.Len(t, enode.Spec.VolumeClaimTemplates, 1)
+	require.Equal(t, "20Gi", enode.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests.Storage().String())
+}
+
+func TestChartTemplateGroupsDefault(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ml"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify a release without groups renders a single StatefulSet
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Equal(t, 1, strings.Count(output, "kind: StatefulSet"))
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	require.Equal(t, "ml", statefulset.Name)
+	require.Equal(t, "default", statefulset.Spec.Selector.MatchLabels["marklogic.com/group"])
+}
+
+func TestChartTemplateGroupsBootstrapHost(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ml"
+	require.NoError(t, err)
+
+	output := helm.RenderTemplate(t, groupsOptions(), helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	script := configmap.Data["poststart-hook.sh"]
+
+	// Verify only the first host of the release bootstraps it, not the first host of a group
+	require.Contains(t, script, `if [[ "${HOSTNAME}" == "ml-0" ]]; then`)
+	require.NotContains(t, script, `== *-0`)
+}
+
+func TestChartTemplateGroupsHAProxyRouting(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ml"
+	require.NoError(t, err)
+
+	output := helm.RenderTemplate(t, groupsOptions(), helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	config := configmap.Data["haproxy.cfg"]
+
+	// Verify each App Server is routed to the hosts of the groups serving it
+	require.Contains(t, config, "server ml-enode-appservices-0 ml-enode-0.ml.marklogic-templ.svc.cluster.local:8000")
+	require.Contains(t, config, "server ml-enode-appservices-1 ml-enode-1.ml.marklogic-templ.svc.cluster.local:8000")
+	require.NotContains(t, config, "ml-0.ml.marklogic-templ.svc.cluster.local:8000")
+	require.Contains(t, config, "server ml-admin-0 ml-0.ml.marklogic-templ.svc.cluster.local:8001")
+	require.Contains(t, config, "server ml-enode-admin-1 ml-enode-1.ml.marklogic-templ.svc.cluster.local:8001")
+	require.Contains(t, config, "server ml-manage-0 ml-0.ml.marklogic-templ.svc.cluster.local:8002")
+	require.Contains(t, config, "server ml-enode-manage-0 ml-enode-0.ml.marklogic-templ.svc.cluster.local:8002")
+	require.Contains(t, config, "server ml-ml-enode-8010-0 ml-enode-0.ml.marklogic-templ.svc.cluster.local:8010")
+	require.NotContains(t, config, "ml-0.ml.marklogic-templ.svc.cluster.local:8010")
+}
+
+func TestChartTemplateGroupsValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "ml"
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		groups        string
+		setValues     map[string]string
+		expectedError string
+	}{
+		"missing name": {
+			groups:        `[{"replicaCount":1}]`,
+			expectedError: "Every entry of groups requires a name.",
+		},
+		"duplicate group": {
+			groups:        `[{"name":"Default"}]`,
+			expectedError: "The group Default is defined more than once in group.name and groups.",
+		},
+		"invalid name": {
+			groups:        `[{"name":"e_node"}]`,
+			expectedError: "The group e_node of groups must consist of alphanumeric characters or '-' to name its StatefulSet.",
+		},
+		"unknown app server": {
+			groups:        `[{"name":"enode","appServers":["dhf"]}]`,
+			expectedError: "The group enode routes the App Server dhf, which is not one of appservices, admin, manage.",
+		},
+		"unrouted app server": {
+			groups:        `[{"name":"enode","appServers":["admin"]}]`,
+			setValues:     map[string]string{"haproxy.enabled": "true", "group.appServers[0]": "admin"},
+			expectedError: "No group routes the App Server appservices, add it to the appServers of a group.",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.setValues,
+				SetJsonValues:  map[string]string{"groups": tc.groups},
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
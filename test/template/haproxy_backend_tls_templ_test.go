@@ -0,0 +1,138 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxyBackendTlsVerify(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":               "true",
+		"haproxy.maxReplicas":           "2",
+		"tls.enableOnDefaultAppServers": "true",
+	})
+
+	// Verify by default each server of the default App Servers checks the certificate of its host with the CA and the FQDN of the pod
+	backends := config.SectionsOf("backend")
+	require.Len(t, backends, 3)
+	for _, backend := range backends {
+		for _, server := range backend.Servers() {
+			fqdn := strings.Split(server.Address, ":")[0]
+			require.Contains(t, server.Options, "ssl", server.Name)
+			verify, _ := server.Option("verify")
+			require.Equal(t, "required", verify, server.Name)
+			caFile, _ := server.Option("ca-file")
+			require.Equal(t, "/usr/local/etc/marklogic-ca/ca.pem", caFile, server.Name)
+			sni, _ := server.Option("sni")
+			require.Equal(t, "str("+fqdn+")", sni, server.Name)
+			verifyHost, _ := server.Option("verifyhost")
+			require.Equal(t, fqdn, verifyHost, server.Name)
+		}
+	}
+	require.Len(t, config.Section("backend", "marklogic-manage").Servers(), 2)
+}
+
+func TestTemplateHAProxyBackendTlsVerifyDisabled(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":               "true",
+		"tls.enableOnDefaultAppServers": "true",
+		"haproxy.backendTls.verify":     "false",
+		"haproxy.pathbased.enabled":     "true",
+		"haproxy.maxReplicas":           "1",
+	})
+
+	// Verify the connections stay encrypted without verifying the hosts
+	for _, backend := range config.SectionsOf("backend") {
+		for _, server := range backend.Servers() {
+			verify, ok := server.Option("verify")
+			require.True(t, ok, server.Name)
+			require.Equal(t, "none", verify, server.Name)
+			require.NotContains(t, server.Options, "ca-file", server.Name)
+		}
+	}
+}
+
+func TestTemplateHAProxyBackendTlsCA(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"haproxy.enabled":               "true",
+			"tls.enableOnDefaultAppServers": "true",
+			"haproxy.backendTls.verify":     "true",
+			"bootstrapStatus.enabled":       "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify the ConfigMap HAProxy mounts is rendered for the bootstrap host to publish the CA
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy-ca.yaml"})
+	var ca corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &ca)
+	require.Equal(t, "ml-haproxy-ca", ca.Name)
+	require.Equal(t, "marklogic-templ", ca.Namespace)
+	require.Empty(t, ca.Data)
+
+	// Verify the bootstrap host may update the ConfigMap even without the bootstrap status
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/rbac.yaml"})
+	var role rbacv1.Role
+	helm.UnmarshalK8SYaml(t, strings.Split(output, "\n---\n")[0], &role)
+	rule := role.Rules[len(role.Rules)-1]
+	require.Equal(t, []string{"configmaps"}, rule.Resources)
+	require.Equal(t, []string{"ml-haproxy-ca"}, rule.ResourceNames)
+	require.Equal(t, []string{"get", "patch"}, rule.Verbs)
+
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/configmap.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, strings.Split(output, "\n---\n")[0], &configmap)
+	require.Equal(t, "ml-haproxy-ca", configmap.Data["HAPROXY_CA_CONFIGMAP"])
+
+	// Verify HAProxy mounts the CA where the servers expect it
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/deployment.yaml"})
+	var deployment appsv1.Deployment
+	helm.UnmarshalK8SYaml(t, output, &deployment)
+	volumes := map[string]corev1.Volume{}
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+	require.Contains(t, volumes, "marklogic-ca")
+	require.Equal(t, "ml-haproxy-ca", volumes["marklogic-ca"].ConfigMap.Name)
+	mounts := map[string]string{}
+	for _, mount := range deployment.Spec.Template.Spec.Containers[0].VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	require.Equal(t, "/usr/local/etc/marklogic-ca", mounts["marklogic-ca"])
+
+	// Verify HAProxy waits for the CA in an init container rather than failing to start without it
+	require.Len(t, deployment.Spec.Template.Spec.InitContainers, 1)
+	wait := deployment.Spec.Template.Spec.InitContainers[0]
+	require.Equal(t, "wait-for-marklogic-ca", wait.Name)
+	require.Contains(t, wait.Command[2], `while grep -q "ca-file $ca" "$config" && [ ! -s "$ca" ]; do`)
+	require.Contains(t, wait.Command[2], "ca=/usr/local/etc/marklogic-ca/ca.pem\n")
+	mounts = map[string]string{}
+	for _, mount := range wait.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	require.Equal(t, map[string]string{"haproxy-config": "/usr/local/etc/haproxy", "marklogic-ca": "/usr/local/etc/marklogic-ca"}, mounts)
+
+	// Verify nothing is published when HAProxy does not verify the hosts
+	options.SetValues["haproxy.backendTls.verify"] = "false"
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy-ca.yaml"})
+	require.Error(t, err)
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/rbac.yaml"})
+	require.Error(t, err)
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/configmap.yaml"})
+	var unverified corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, strings.Split(output, "\n---\n")[0], &unverified)
+	require.NotContains(t, unverified.Data, "HAPROXY_CA_CONFIGMAP")
+}
@@ -0,0 +1,148 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxyClientIPForwarding(t *testing.T) {
+	tests := map[string]struct {
+		pathbased string
+		backends  []string
+	}{
+		"port based": {"false", []string{"marklogic-appservices", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+		"path based": {"true", []string{"marklogic-app-services", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := renderHAProxyConfig(t, map[string]string{
+				"haproxy.enabled":                      "true",
+				"haproxy.pathbased.enabled":            tc.pathbased,
+				"haproxy.clientIP.proxyProtocol":       "true",
+				"haproxy.additionalAppServers[0].name": "dhf",
+				"haproxy.additionalAppServers[0].type": "HTTP",
+				"haproxy.additionalAppServers[0].port": "8010",
+				"haproxy.additionalAppServers[0].path": "/dhf",
+				"haproxy.tcpports.enabled":             "true",
+				"haproxy.tcpports.ports[0].name":       "odbc",
+				"haproxy.tcpports.ports[0].type":       "TCP",
+				"haproxy.tcpports.ports[0].port":       "5432",
+			})
+
+			// Verify the client IP of the PROXY header replaces the forwarding headers sent by the client, before the paths are rewritten
+			for _, name := range tc.backends {
+				backend := config.Section("backend", name)
+				require.NotNil(t, backend, name)
+				require.Equal(t, [][]string{
+					{"http-request", "set-var(txn.client_ip)", "src"},
+					{"http-request", "set-header", "X-Forwarded-For", "%[var(txn.client_ip)]"},
+					{"http-request", "set-header", "X-Real-IP", "%[var(txn.client_ip)]"},
+				}, backend.Find("http-request")[:3], name)
+				require.Equal(t, [][]string{{"option", "forwardfor", "if-none"}}, backend.Find("option", "forwardfor"), name)
+			}
+
+			// Verify TCP routes forward the connections unchanged
+			listen := config.Section("listen", "marklogic-TCP-5432")
+			require.NotNil(t, listen)
+			require.False(t, listen.Has("http-request"))
+		})
+	}
+}
+
+func TestTemplateHAProxyClientIPForwardingHeader(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                 "true",
+		"haproxy.pathbased.enabled":       "true",
+		"haproxy.clientIP.header":         "X-Forwarded-For",
+		"haproxy.clientIP.trustedProxies": "{10.244.0.0/16}",
+	})
+
+	// Verify MarkLogic receives the address the trusted proxy added rather than the address of the proxy
+	manage := config.Section("backend", "marklogic-manage")
+	require.Equal(t, [][]string{
+		{"http-request", "set-var(txn.client_ip)", "src"},
+		{"http-request", "set-var(txn.client_ip)", "req.hdr_ip(X-Forwarded-For,-1)", "if", "{", "src", "10.244.0.0/16", "}", "{", "req.hdr(X-Forwarded-For)", "-m", "found", "}"},
+		{"http-request", "set-header", "X-Forwarded-For", "%[var(txn.client_ip)]"},
+		{"http-request", "set-header", "X-Real-IP", "%[var(txn.client_ip)]"},
+	}, manage.Find("http-request")[:4])
+	require.True(t, manage.Has("option", "forwardfor", "if-none"))
+	for _, bind := range config.Section("frontend", "marklogic").Find("bind") {
+		require.NotContains(t, bind, "accept-proxy")
+	}
+}
+
+func TestTemplateHAProxyClientIPForwardingDisabled(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled": "true",
+	})
+
+	// Verify HAProxy only adds the address of the connection by default
+	backends := config.SectionsOf("backend")
+	require.Len(t, backends, 3)
+	for _, backend := range backends {
+		require.Equal(t, [][]string{{"option", "forwardfor"}}, backend.Find("option", "forwardfor"), backend.Name)
+		require.False(t, backend.Has("http-request", "set-header", "X-Real-IP"), backend.Name)
+	}
+}
+
+func TestTemplateHAProxyServiceExternalTrafficPolicy(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"haproxy.enabled":                       "true",
+			"haproxy.service.type":                  "LoadBalancer",
+			"haproxy.service.externalTrafficPolicy": "Local",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/service.yaml"})
+	var service corev1.Service
+	helm.UnmarshalK8SYaml(t, output, &service)
+	require.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+	require.Equal(t, corev1.ServiceExternalTrafficPolicyLocal, service.Spec.ExternalTrafficPolicy)
+
+	// Verify the policy of Kubernetes applies by default
+	delete(options.SetValues, "haproxy.service.externalTrafficPolicy")
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/service.yaml"})
+	var defaultService corev1.Service
+	helm.UnmarshalK8SYaml(t, output, &defaultService)
+	require.Empty(t, defaultService.Spec.ExternalTrafficPolicy)
+}
+
+func TestTemplateHAProxyServiceExternalTrafficPolicyValidation(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		setValues map[string]string
+		message   string
+	}{
+		"unknown policy": {map[string]string{
+			"haproxy.service.type":                  "LoadBalancer",
+			"haproxy.service.externalTrafficPolicy": "local",
+		}, `haproxy.service.externalTrafficPolicy is "local". It must be Cluster or Local.`},
+		"cluster IP service": {map[string]string{
+			"haproxy.service.externalTrafficPolicy": "Local",
+		}, "haproxy.service.externalTrafficPolicy requires haproxy.service.type LoadBalancer or NodePort."},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.setValues["haproxy.enabled"] = "true"
+			options := &helm.Options{
+				SetValues:      tc.setValues,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
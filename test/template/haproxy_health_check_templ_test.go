@@ -0,0 +1,178 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/marklogic/marklogic-kubernetes/test/testUtil"
+)
+
+func renderHAProxyConfig(t *testing.T, setValues map[string]string) *testUtil.HAProxyConfig {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues:      setValues,
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	return testUtil.ParseHAProxyConfig(t, configmap.Data["haproxy.cfg"])
+}
+
+func TestParseHAProxyConfig(t *testing.T) {
+	config := testUtil.ParseHAProxyConfig(t, `
+global
+  log stdout format raw local0 # logs go to the container
+frontend marklogic
+  log-format "%ci:%cp %{+Q}r"
+  http-request replace-path /console(/)?(.*) /\2
+backend marklogic-manage
+  server ml-0 ml-0.ml:8002 check cookie ml-0
+`)
+	require.Len(t, config.Sections, 3)
+	require.Equal(t, [][]string{{"log", "stdout", "format", "raw", "local0"}}, config.Section("global", "").Directives)
+	frontend := config.Section("frontend", "marklogic")
+	require.Equal(t, []string{"log-format", "%ci:%cp %{+Q}r"}, frontend.Find("log-format")[0])
+	require.Equal(t, []string{"http-request", "replace-path", "/console(/)?(.*)", `/\2`}, frontend.Find("http-request", "replace-path")[0])
+	servers := config.Section("backend", "marklogic-manage").Servers()
+	require.Equal(t, "ml-0.ml:8002", servers[0].Address)
+	cookie, ok := servers[0].Option("cookie")
+	require.True(t, ok)
+	require.Equal(t, "ml-0", cookie)
+	require.Nil(t, config.Section("backend", "marklogic-admin"))
+}
+
+func TestTemplateHAProxyHealthCheck(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                                  "true",
+		"haproxy.maxReplicas":                              "2",
+		"haproxy.healthCheck.interval":                     "2s",
+		"haproxy.healthCheck.rise":                         "4",
+		"haproxy.healthCheck.fall":                         "5",
+		"haproxy.defaultAppServers.manage.healthCheckPath": "/manage/v2/health",
+		"haproxy.tcpports.enabled":                         "true",
+		"haproxy.tcpports.ports[0].name":                   "odbc",
+		"haproxy.tcpports.ports[0].type":                   "TCP",
+		"haproxy.tcpports.ports[0].port":                   "5432",
+	})
+
+	// Verify the hosts are checked on the HealthCheck App Server and drained when they fail
+	for _, name := range []string{"marklogic-appservices", "marklogic-admin"} {
+		backend := config.Section("backend", name)
+		require.NotNil(t, backend, name)
+		require.True(t, backend.Has("option", "httpchk"))
+		require.Equal(t, []string{"http-check", "send", "meth", "GET", "uri", "/"}, backend.Find("http-check", "send")[0])
+		require.True(t, backend.Has("http-check", "expect", "status", "200"))
+		require.True(t, backend.Has("option", "redispatch"))
+		require.True(t, backend.Has("retry-on", "conn-failure"))
+		require.Equal(t, []string{"default-server", "check", "port", "7997", "inter", "2s", "rise", "4", "fall", "5", "agent-check", "agent-port", "7996", "agent-inter", "2s"}, backend.Find("default-server")[0])
+		require.Len(t, backend.Servers(), 2)
+	}
+
+	// Verify an App Server with a healthCheckPath is checked on its own port
+	manage := config.Section("backend", "marklogic-manage")
+	require.Equal(t, []string{"http-check", "send", "meth", "GET", "uri", "/manage/v2/health"}, manage.Find("http-check", "send")[0])
+	require.Equal(t, []string{"default-server", "check", "inter", "2s", "rise", "4", "fall", "5", "agent-check", "agent-port", "7996", "agent-inter", "2s"}, manage.Find("default-server")[0])
+
+	// Verify the TCP ports are checked on the HealthCheck App Server
+	odbc := config.Section("listen", "marklogic-TCP-5432")
+	require.NotNil(t, odbc)
+	require.True(t, odbc.Has("option", "httpchk"))
+	require.True(t, odbc.Has("default-server", "check", "port", "7997"))
+}
+
+func TestTemplateHAProxyHealthCheckPathBased(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                                 "true",
+		"haproxy.pathbased.enabled":                       "true",
+		"haproxy.additionalAppServers[0].name":            "dhf",
+		"haproxy.additionalAppServers[0].type":            "HTTP",
+		"haproxy.additionalAppServers[0].port":            "8010",
+		"haproxy.additionalAppServers[0].path":            "/dhf",
+		"haproxy.additionalAppServers[0].healthCheckPath": "/dhf/ping",
+	})
+
+	require.True(t, config.Section("backend", "marklogic-app-services").Has("default-server", "check", "port", "7997"))
+	dhf := config.Section("backend", "marklogic-8010")
+	require.NotNil(t, dhf)
+	require.True(t, dhf.Has("http-check", "send", "meth", "GET", "uri", "/dhf/ping"))
+	require.False(t, dhf.Has("default-server", "check", "port"))
+}
+
+func TestTemplateHAProxyHealthCheckDisabled(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":             "true",
+		"haproxy.healthCheck.enabled": "false",
+		"haproxy.drain.enabled":       "false",
+	})
+
+	// Verify HAProxy falls back to connecting to the port of the App Server
+	for _, backend := range config.SectionsOf("backend") {
+		require.Equal(t, [][]string{{"default-server", "check"}}, backend.Find("default-server"), backend.Name)
+		require.False(t, backend.Has("option", "httpchk"), backend.Name)
+		require.False(t, backend.Has("option", "redispatch"), backend.Name)
+	}
+}
+
+func TestTemplateHAProxyDrain(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues:      map[string]string{"haproxy.enabled": "true"},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify HAProxy asks the agent of each host whether it is draining
+	config := renderHAProxyConfig(t, options.SetValues)
+	for _, backend := range config.SectionsOf("backend") {
+		defaultServer := backend.Find("default-server")[0]
+		require.Equal(t, []string{"agent-check", "agent-port", "7996", "agent-inter", "2s"}, defaultServer[len(defaultServer)-5:], backend.Name)
+	}
+
+	// Verify the agent runs next to MarkLogic and shares the drain marker with it
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	containers := map[string]corev1.Container{}
+	for _, container := range statefulset.Spec.Template.Spec.Containers {
+		containers[container.Name] = container
+	}
+	agent, ok := containers["haproxy-agent"]
+	require.True(t, ok)
+	require.Equal(t, []string{"python3", "/tmp/helm-scripts/haproxy-agent.py"}, agent.Command)
+	require.Equal(t, int32(7996), agent.Ports[0].ContainerPort)
+	for _, container := range []corev1.Container{agent, containers["marklogic-server"]} {
+		require.Contains(t, container.VolumeMounts, corev1.VolumeMount{Name: "haproxy-drain", MountPath: "/tmp/haproxy-drain"}, container.Name)
+	}
+
+	// Verify the preStop hook drains the host for two agent intervals and the timeout before MarkLogic shuts down
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	prestop := configmap.Data["prestop-hook.sh"]
+	require.Contains(t, prestop, "touch /tmp/haproxy-drain/draining\n")
+	require.Contains(t, prestop, "sleep 34\n")
+	require.Less(t, strings.Index(prestop, "sleep 34"), strings.Index(prestop, "state=shutdown"))
+	require.Contains(t, configmap.Data["haproxy-agent.py"], `ThreadingTCPServer(("", 7996), Agent)`)
+	require.Contains(t, configmap.Data["poststart-hook.sh"], "rm -f /tmp/haproxy-drain/draining\n")
+
+	// Verify the drain must leave MarkLogic time to shut down within the grace period
+	options.SetValues["terminationGracePeriod"] = "30"
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "The drain of a host from HAProxy takes 34 seconds")
+
+	// Verify nothing is drained when disabled
+	options.SetValues["haproxy.drain.enabled"] = "false"
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	require.NotContains(t, output, "haproxy-agent")
+	require.NotContains(t, output, "haproxy-drain")
+}
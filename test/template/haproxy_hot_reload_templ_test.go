@@ -0,0 +1,76 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func renderHAProxyDeployment(t *testing.T, setValues map[string]string) appsv1.Deployment {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues:      setValues,
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/deployment.yaml"})
+	var deployment appsv1.Deployment
+	helm.UnmarshalK8SYaml(t, output, &deployment)
+	return deployment
+}
+
+func TestTemplateHAProxyHotReload(t *testing.T) {
+	deployment := renderHAProxyDeployment(t, map[string]string{
+		"haproxy.enabled":                    "true",
+		"haproxy.restartWhenUpgrade.enabled": "true",
+		"haproxy.hotReload.enabled":          "true",
+		"haproxy.hotReload.interval":         "2",
+	})
+	spec := deployment.Spec.Template.Spec
+
+	// Verify an upgrade does not roll the pods, the sidecar signals HAProxy in the shared process namespace
+	require.NotContains(t, deployment.Spec.Template.Annotations, "rollme")
+	require.NotNil(t, spec.ShareProcessNamespace)
+	require.True(t, *spec.ShareProcessNamespace)
+	require.Len(t, spec.Containers, 2)
+
+	// Verify HAProxy stays the first container and reads the configmap through a directory the kubelet updates
+	haproxy := spec.Containers[0]
+	require.Equal(t, "haproxy", haproxy.Name)
+	require.Equal(t, []string{"-f", "/usr/local/etc/haproxy/haproxy.cfg"}, haproxy.Args)
+	require.Equal(t, "haproxy-config", haproxy.VolumeMounts[0].Name)
+	require.Equal(t, "/usr/local/etc/haproxy", haproxy.VolumeMounts[0].MountPath)
+	require.Empty(t, haproxy.VolumeMounts[0].SubPath)
+
+	reloader := spec.Containers[1]
+	require.Equal(t, "config-reloader", reloader.Name)
+	require.Equal(t, haproxy.Image, reloader.Image)
+	require.Len(t, reloader.VolumeMounts, 1)
+	require.Equal(t, haproxy.VolumeMounts[0], reloader.VolumeMounts[0])
+	require.Equal(t, []string{"/bin/sh", "-c"}, reloader.Command[:2])
+	script := reloader.Command[2]
+	require.Contains(t, script, "config=/usr/local/etc/haproxy/haproxy.cfg")
+	require.Contains(t, script, "while sleep 2; do")
+	require.Contains(t, script, "pkill -USR2 -o -x haproxy")
+	require.Equal(t, "10m", reloader.Resources.Requests.Cpu().String())
+}
+
+func TestTemplateHAProxyHotReloadDisabled(t *testing.T) {
+	deployment := renderHAProxyDeployment(t, map[string]string{
+		"haproxy.enabled": "true",
+	})
+	spec := deployment.Spec.Template.Spec
+
+	// Verify the pods roll on upgrades and mount only the configuration file by default
+	require.Contains(t, deployment.Spec.Template.Annotations, "rollme")
+	require.Nil(t, spec.ShareProcessNamespace)
+	require.Len(t, spec.Containers, 1)
+	mount := spec.Containers[0].VolumeMounts[0]
+	require.Equal(t, "/usr/local/etc/haproxy/haproxy.cfg", mount.MountPath)
+	require.Equal(t, "haproxy.cfg", mount.SubPath)
+}
@@ -0,0 +1,164 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxyRateLimit(t *testing.T) {
+	tests := map[string]struct {
+		pathbased string
+		backends  []string
+	}{
+		"port based": {"false", []string{"marklogic-appservices", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+		"path based": {"true", []string{"marklogic-app-services", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := renderHAProxyConfig(t, map[string]string{
+				"haproxy.enabled":                      "true",
+				"haproxy.pathbased.enabled":            tc.pathbased,
+				"haproxy.rateLimit.maxConnections":     "10",
+				"haproxy.rateLimit.requestsPerSecond":  "20",
+				"haproxy.rateLimit.maxBodySize":        "10485760000",
+				"haproxy.rateLimit.tableSize":          "50k",
+				"haproxy.additionalAppServers[0].name": "dhf",
+				"haproxy.additionalAppServers[0].type": "HTTP",
+				"haproxy.additionalAppServers[0].port": "8010",
+				"haproxy.additionalAppServers[0].path": "/dhf",
+			})
+
+			for _, name := range tc.backends {
+				backend := config.Section("backend", name)
+				require.NotNil(t, backend, name)
+
+				// Verify the clients are tracked by source IP in the table of the App Server before the limits apply
+				rules := backend.Find("http-request")
+				require.Equal(t, []string{"http-request", "track-sc0", "src", "table", name + "-limits"}, rules[0], name)
+				require.True(t, backend.Has("http-request", "deny", "deny_status", "429", "if", "{", "sc0_conn_cur", "gt", "10", "}"), name)
+				require.True(t, backend.Has("http-request", "deny", "deny_status", "429", "if", "{", "sc0_http_req_rate", "gt", "20", "}"), name)
+				require.True(t, backend.Has("http-request", "deny", "deny_status", "413", "if", "{", "req.hdr_val(content-length)", "gt", "10485760000", "}"), name)
+				require.True(t, backend.Has("http-request", "deny", "deny_status", "411", "if", "{", "req.hdr(transfer-encoding)", "-m", "found", "}"), name)
+
+				// Verify the table is its own section, the stick table of the backend keeps the session affinity
+				table := config.Section("backend", name+"-limits")
+				require.NotNil(t, table, name)
+				require.Equal(t, [][]string{{"stick-table", "type", "ip", "size", "50k", "expire", "10s", "store", "conn_cur,http_req_rate(1s)"}}, table.Directives)
+				require.Empty(t, table.Servers())
+				require.True(t, backend.Has("stick-table", "type", "string"), name)
+			}
+		})
+	}
+}
+
+func TestTemplateHAProxyRateLimitPerAppServer(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                                             "true",
+		"haproxy.rateLimit.requestsPerSecond":                         "20",
+		"haproxy.defaultAppServers.admin.rateLimit.requestsPerSecond": "0",
+		"haproxy.defaultAppServers.admin.rateLimit.maxBodySize":       "1024",
+		"haproxy.additionalAppServers[0].name":                        "dhf",
+		"haproxy.additionalAppServers[0].type":                        "HTTP",
+		"haproxy.additionalAppServers[0].port":                        "8010",
+		"haproxy.additionalAppServers[0].rateLimit.maxConnections":    "5",
+	})
+
+	// Verify an App Server without rateLimit inherits the limits of haproxy.rateLimit
+	manage := config.Section("backend", "marklogic-manage")
+	require.Len(t, manage.Find("http-request", "track-sc0"), 1)
+	require.Len(t, manage.Find("http-request", "deny"), 1)
+	require.True(t, manage.Has("http-request", "deny", "deny_status", "429", "if", "{", "sc0_http_req_rate", "gt", "20", "}"))
+	require.NotNil(t, config.Section("backend", "marklogic-manage-limits"))
+
+	// Verify a body size limit does not need a table, and refuses the chunked bodies it cannot measure up front
+	admin := config.Section("backend", "marklogic-admin")
+	require.Equal(t, [][]string{
+		{"http-request", "deny", "deny_status", "411", "if", "{", "req.hdr(transfer-encoding)", "-m", "found", "}"},
+		{"http-request", "deny", "deny_status", "413", "if", "{", "req.hdr_val(content-length)", "gt", "1024", "}"},
+	}, admin.Find("http-request"))
+	require.False(t, manage.Has("http-request", "deny", "deny_status", "411"))
+	require.Nil(t, config.Section("backend", "marklogic-admin-limits"))
+
+	// Verify the limits of an App Server add to the inherited ones
+	dhf := config.Section("backend", "marklogic-8010")
+	require.True(t, dhf.Has("http-request", "deny", "deny_status", "429", "if", "{", "sc0_conn_cur", "gt", "5", "}"))
+	require.True(t, dhf.Has("http-request", "deny", "deny_status", "429", "if", "{", "sc0_http_req_rate", "gt", "20", "}"))
+}
+
+func TestTemplateHAProxyRateLimitClientIP(t *testing.T) {
+	tests := map[string]struct {
+		values map[string]string
+		key    string
+	}{
+		"header": {map[string]string{
+			"haproxy.clientIP.header":         "X-Forwarded-For",
+			"haproxy.clientIP.trustedProxies": "{10.244.0.0/16}",
+		}, "var(txn.client_ip)"},
+		"proxy protocol": {map[string]string{
+			"haproxy.clientIP.proxyProtocol": "true",
+		}, "src"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			setValues := map[string]string{
+				"haproxy.enabled":                     "true",
+				"haproxy.rateLimit.requestsPerSecond": "20",
+			}
+			for key, value := range tc.values {
+				setValues[key] = value
+			}
+			config := renderHAProxyConfig(t, setValues)
+
+			for _, backend := range config.SectionsOf("backend") {
+				rules := backend.Find("http-request")
+				if len(rules) == 0 {
+					continue
+				}
+				// Verify the clients behind a load balancer are tracked apart, by the client IP set before the limits
+				track := -1
+				for i, rule := range rules {
+					if len(rule) > 1 && rule[1] == "track-sc0" {
+						track = i
+					}
+				}
+				require.NotEqual(t, -1, track, backend.Name)
+				require.Equal(t, []string{"http-request", "track-sc0", tc.key, "table", backend.Name + "-limits"}, rules[track], backend.Name)
+				require.Equal(t, "set-var(txn.client_ip)", rules[0][1], backend.Name)
+			}
+		})
+	}
+}
+
+func TestTemplateHAProxyRateLimitDisabled(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":           "true",
+		"haproxy.pathbased.enabled": "true",
+	})
+
+	for _, backend := range config.SectionsOf("backend") {
+		require.False(t, backend.Has("http-request", "track-sc0"), backend.Name)
+		require.False(t, backend.Has("http-request", "deny"), backend.Name)
+	}
+	require.Len(t, config.SectionsOf("backend"), 3)
+}
+
+func TestTemplateHAProxyRateLimitValidation(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"haproxy.enabled": "true",
+			"haproxy.defaultAppServers.manage.rateLimit.requestsPerSecond": "-1",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "The rateLimit.requestsPerSecond -1 of the App Server manage must be a number of 0 or more.")
+}
@@ -0,0 +1,146 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxySessionAffinity(t *testing.T) {
+	tests := map[string]struct {
+		mode     string
+		expected [][]string
+		cookie   bool
+	}{
+		"cookie": {
+			mode: "cookie",
+			expected: [][]string{
+				{"cookie", "haproxy", "insert", "indirect", "httponly", "nocache", "maxidle", "1h", "maxlife", "24h"},
+				{"stick-table", "type", "string", "len", "32", "size", "10k", "expire", "24h"},
+				{"stick", "match", "req.cook(HostId)"},
+				{"stick", "match", "req.cook(SessionId)"},
+			},
+			cookie: true,
+		},
+		"session": {
+			mode: "session",
+			expected: [][]string{
+				{"stick-table", "type", "string", "len", "32", "size", "10k", "expire", "24h"},
+				{"stick", "store-response", "res.cook(HostId)"},
+				{"stick", "store-response", "res.cook(SessionId)"},
+			},
+		},
+		"source": {
+			mode: "source",
+			expected: [][]string{
+				{"stick-table", "type", "ip", "size", "100k", "expire", "1h"},
+				{"stick", "on", "src"},
+			},
+		},
+		"none": {
+			mode: "none",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, pathbased := range []string{"false", "true"} {
+				config := renderHAProxyConfig(t, map[string]string{
+					"haproxy.enabled":                       "true",
+					"haproxy.pathbased.enabled":             pathbased,
+					"haproxy.maxReplicas":                   "2",
+					"haproxy.sessionAffinity.mode":          tc.mode,
+					"haproxy.sessionAffinity.cookieMaxIdle": "1h",
+					"haproxy.sessionAffinity.cookieMaxLife": "24h",
+					"haproxy.sessionAffinity.balance":       "roundrobin",
+					"haproxy.additionalAppServers[0].name":  "dhf",
+					"haproxy.additionalAppServers[0].type":  "HTTP",
+					"haproxy.additionalAppServers[0].port":  "8010",
+					"haproxy.additionalAppServers[0].path":  "/dhf",
+				})
+
+				backends := config.SectionsOf("backend")
+				require.Len(t, backends, 4)
+				for _, backend := range backends {
+					require.Equal(t, [][]string{{"balance", "roundrobin"}}, backend.Find("balance"), backend.Name)
+					for _, directive := range tc.expected {
+						require.True(t, backend.Has(directive...), "%s: %v", backend.Name, directive)
+					}
+					if tc.mode != "cookie" {
+						require.False(t, backend.Has("cookie"), backend.Name)
+					}
+					if tc.mode == "none" {
+						require.False(t, backend.Has("stick-table"), backend.Name)
+						require.False(t, backend.Has("stick"), backend.Name)
+					}
+					// Verify the servers are named in the cookie only when HAProxy inserts it
+					for _, server := range backend.Servers() {
+						cookie, ok := server.Option("cookie")
+						require.Equal(t, tc.cookie, ok, server.Name)
+						if ok {
+							require.NotEmpty(t, cookie)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateHAProxySessionAffinityPerAppServer(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled": "true",
+		"haproxy.defaultAppServers.manage.sessionAffinity.mode":         "none",
+		"haproxy.defaultAppServers.manage.sessionAffinity.balance":      "roundrobin",
+		"haproxy.additionalAppServers[0].name":                          "dhf",
+		"haproxy.additionalAppServers[0].type":                          "HTTP",
+		"haproxy.additionalAppServers[0].port":                          "8010",
+		"haproxy.additionalAppServers[0].path":                          "/dhf",
+		"haproxy.additionalAppServers[0].sessionAffinity.cookieMaxLife": "24h",
+		"haproxy.additionalAppServers[1].name":                          "rest",
+		"haproxy.additionalAppServers[1].type":                          "HTTP",
+		"haproxy.additionalAppServers[1].port":                          "8011",
+		"haproxy.additionalAppServers[1].path":                          "/rest",
+		"haproxy.additionalAppServers[1].sessionAffinity.mode":          "source",
+		"haproxy.additionalAppServers[1].sessionAffinity.cookieMaxIdle": "5m",
+	})
+
+	// Verify the App Servers without sessionAffinity keep the default cookie affinity
+	appservices := config.Section("backend", "marklogic-appservices")
+	require.True(t, appservices.Has("cookie", "haproxy", "insert", "indirect", "httponly", "nocache", "maxidle", "30m", "maxlife", "4h"))
+	require.True(t, appservices.Has("balance", "leastconn"))
+
+	manage := config.Section("backend", "marklogic-manage")
+	require.True(t, manage.Has("balance", "roundrobin"))
+	require.False(t, manage.Has("cookie"))
+	require.False(t, manage.Has("stick-table"))
+
+	// Verify settings an App Server does not override are inherited
+	dhf := config.Section("backend", "marklogic-8010")
+	require.True(t, dhf.Has("cookie", "haproxy", "insert", "indirect", "httponly", "nocache", "maxidle", "30m", "maxlife", "24h"))
+	require.True(t, dhf.Has("stick-table", "type", "string", "len", "32", "size", "10k", "expire", "24h"))
+
+	rest := config.Section("backend", "marklogic-8011")
+	require.True(t, rest.Has("stick-table", "type", "ip", "size", "100k", "expire", "5m"))
+	require.True(t, rest.Has("stick", "on", "src"))
+	require.True(t, rest.Has("balance", "leastconn"))
+}
+
+func TestTemplateHAProxySessionAffinityInvalidMode(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"haproxy.enabled": "true",
+			"haproxy.defaultAppServers.admin.sessionAffinity.mode": "sticky",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "The sessionAffinity.mode sticky of the App Server admin must be one of none, cookie, source or session.")
+}
@@ -0,0 +1,206 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxySourceRanges(t *testing.T) {
+	tests := map[string]struct {
+		pathbased string
+		backends  []string
+	}{
+		"port based": {"false", []string{"marklogic-appservices", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+		"path based": {"true", []string{"marklogic-app-services", "marklogic-admin", "marklogic-manage", "marklogic-8010"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := renderHAProxyConfig(t, map[string]string{
+				"haproxy.enabled":                      "true",
+				"haproxy.pathbased.enabled":            tc.pathbased,
+				"haproxy.sourceRanges.allow":           "{10.0.0.0/8,fd00::/8}",
+				"haproxy.sourceRanges.deny":            "{10.1.2.3}",
+				"haproxy.rateLimit.requestsPerSecond":  "20",
+				"haproxy.additionalAppServers[0].name": "dhf",
+				"haproxy.additionalAppServers[0].type": "HTTP",
+				"haproxy.additionalAppServers[0].port": "8010",
+				"haproxy.additionalAppServers[0].path": "/dhf",
+			})
+
+			for _, name := range tc.backends {
+				backend := config.Section("backend", name)
+				require.NotNil(t, backend, name)
+
+				// Verify the client IP is the source address, denied before it is allowed and before the rate limits apply
+				rules := backend.Find("http-request")
+				require.Equal(t, []string{"http-request", "set-var(txn.client_ip)", "src"}, rules[0], name)
+				require.Equal(t, []string{"http-request", "deny", "deny_status", "403", "if", "{", "var(txn.client_ip)", "-m", "ip", "10.1.2.3", "}"}, rules[1], name)
+				require.Equal(t, []string{"http-request", "deny", "deny_status", "403", "unless", "{", "var(txn.client_ip)", "-m", "ip", "10.0.0.0/8", "fd00::/8", "}"}, rules[2], name)
+				require.Equal(t, "track-sc0", rules[3][1], name)
+			}
+		})
+	}
+}
+
+func TestTemplateHAProxySourceRangesPerAppServer(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                                    "true",
+		"haproxy.sourceRanges.deny":                          "{192.0.2.0/24}",
+		"haproxy.defaultAppServers.admin.sourceRanges.allow": "{10.8.0.0/16}",
+		"haproxy.additionalAppServers[0].name":               "dhf",
+		"haproxy.additionalAppServers[0].type":               "HTTP",
+		"haproxy.additionalAppServers[0].port":               "8010",
+		"haproxy.additionalAppServers[0].sourceRanges.deny":  "{198.51.100.7}",
+		"haproxy.tcpports.enabled":                           "true",
+		"haproxy.tcpports.ports[0].name":                     "odbc",
+		"haproxy.tcpports.ports[0].type":                     "TCP",
+		"haproxy.tcpports.ports[0].port":                     "5432",
+		"haproxy.tcpports.ports[0].sourceRanges.allow":       "{10.8.0.0/16}",
+	})
+
+	// Verify an App Server without sourceRanges inherits haproxy.sourceRanges
+	appservices := config.Section("backend", "marklogic-appservices")
+	require.True(t, appservices.Has("http-request", "deny", "deny_status", "403", "if", "{", "var(txn.client_ip)", "-m", "ip", "192.0.2.0/24", "}"))
+	require.False(t, appservices.Has("http-request", "deny", "deny_status", "403", "unless"))
+
+	// Verify the allow list of an App Server adds to the inherited deny list
+	admin := config.Section("backend", "marklogic-admin")
+	require.True(t, admin.Has("http-request", "deny", "deny_status", "403", "if", "{", "var(txn.client_ip)", "-m", "ip", "192.0.2.0/24", "}"))
+	require.True(t, admin.Has("http-request", "deny", "deny_status", "403", "unless", "{", "var(txn.client_ip)", "-m", "ip", "10.8.0.0/16", "}"))
+
+	// Verify the deny list of an App Server replaces the inherited one
+	dhf := config.Section("backend", "marklogic-8010")
+	require.Equal(t, [][]string{{"http-request", "deny", "deny_status", "403", "if", "{", "var(txn.client_ip)", "-m", "ip", "198.51.100.7", "}"}}, dhf.Find("http-request", "deny"))
+
+	// Verify TCP routes reject connections by their source address
+	odbc := config.Section("listen", "marklogic-TCP-5432")
+	require.NotNil(t, odbc)
+	require.Equal(t, [][]string{
+		{"tcp-request", "content", "reject", "if", "{", "src", "192.0.2.0/24", "}"},
+		{"tcp-request", "content", "reject", "unless", "{", "src", "10.8.0.0/16", "}"},
+	}, odbc.Find("tcp-request"))
+	require.False(t, odbc.Has("http-request"))
+}
+
+func TestTemplateHAProxySourceRangesPassthrough(t *testing.T) {
+	values := clientAuthValues()
+	values["haproxy.additionalAppServers[0].sourceRanges.deny"] = "{198.51.100.7}"
+	config := renderHAProxyConfig(t, values)
+
+	// Verify MarkLogic verifying the client certificates does not bypass the source ranges
+	listen := config.Section("listen", "marklogic-8010")
+	require.NotNil(t, listen)
+	require.Equal(t, [][]string{{"tcp-request", "content", "reject", "if", "{", "src", "198.51.100.7", "}"}}, listen.Find("tcp-request"))
+}
+
+func TestTemplateHAProxyClientIP(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                      "true",
+		"haproxy.pathbased.enabled":            "true",
+		"haproxy.stats.enabled":                "true",
+		"haproxy.clientIP.proxyProtocol":       "true",
+		"haproxy.clientIP.header":              "X-Forwarded-For",
+		"haproxy.clientIP.trustedProxies":      "{10.0.0.0/8,172.16.0.0/12}",
+		"haproxy.sourceRanges.allow":           "{203.0.113.0/24}",
+		"haproxy.additionalAppServers[0].name": "dhf",
+		"haproxy.additionalAppServers[0].type": "HTTP",
+		"haproxy.additionalAppServers[0].port": "8010",
+		"haproxy.additionalAppServers[0].path": "/dhf",
+	})
+
+	// Verify the last address of the header is the client IP only when a trusted proxy sets it
+	manage := config.Section("backend", "marklogic-manage")
+	rules := manage.Find("http-request", "set-var(txn.client_ip)")
+	require.Len(t, rules, 2)
+	require.Equal(t, []string{"http-request", "set-var(txn.client_ip)", "src"}, rules[0])
+	require.Equal(t, []string{
+		"http-request", "set-var(txn.client_ip)", "req.hdr_ip(X-Forwarded-For,-1)",
+		"if", "{", "src", "10.0.0.0/8", "172.16.0.0/12", "}", "{", "req.hdr(X-Forwarded-For)", "-m", "found", "}",
+	}, rules[1])
+
+	// Verify the frontends expect the PROXY protocol, except stats scraped inside the cluster
+	bind := config.Section("frontend", "marklogic").Find("bind")
+	require.Equal(t, [][]string{{"bind", ":443", "accept-proxy"}}, bind)
+	require.Equal(t, [][]string{{"bind", "*:1024"}}, config.Section("frontend", "stats").Find("bind"))
+
+	config = renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                      "true",
+		"haproxy.clientIP.proxyProtocol":       "true",
+		"haproxy.additionalAppServers[0].name": "dhf",
+		"haproxy.additionalAppServers[0].type": "HTTP",
+		"haproxy.additionalAppServers[0].port": "8010",
+		"haproxy.tcpports.enabled":             "true",
+		"haproxy.tcpports.ports[0].name":       "odbc",
+		"haproxy.tcpports.ports[0].type":       "TCP",
+		"haproxy.tcpports.ports[0].port":       "5432",
+	})
+	sections := append(config.SectionsOf("frontend"), config.SectionsOf("listen")...)
+	require.Len(t, sections, 5)
+	for _, section := range sections {
+		bind := section.Find("bind")
+		require.Len(t, bind, 1, section.Name)
+		require.Equal(t, "accept-proxy", bind[0][len(bind[0])-1], section.Name)
+	}
+}
+
+func TestTemplateHAProxySourceRangesDisabled(t *testing.T) {
+	config := renderHAProxyConfig(t, map[string]string{
+		"haproxy.enabled":                "true",
+		"haproxy.tcpports.enabled":       "true",
+		"haproxy.tcpports.ports[0].name": "odbc",
+		"haproxy.tcpports.ports[0].type": "TCP",
+		"haproxy.tcpports.ports[0].port": "5432",
+	})
+
+	for _, section := range config.Sections {
+		require.False(t, section.Has("http-request", "set-var(txn.client_ip)"), section.Name)
+		require.False(t, section.Has("tcp-request"), section.Name)
+		for _, bind := range section.Find("bind") {
+			require.NotContains(t, bind, "accept-proxy", section.Name)
+		}
+	}
+}
+
+func TestTemplateHAProxySourceRangesValidation(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		template  string
+		setValues map[string]string
+		message   string
+	}{
+		"source range": {
+			"templates/configmap-haproxy.yaml",
+			map[string]string{"haproxy.defaultAppServers.admin.sourceRanges.allow": "{vpn}"},
+			"The sourceRanges.allow entry vpn of the App Server admin must be an IP address or a CIDR.",
+		},
+		"header without trusted proxies": {
+			"templates/statefulset.yaml",
+			map[string]string{"haproxy.clientIP.header": "X-Forwarded-For"},
+			"haproxy.clientIP.header requires haproxy.clientIP.trustedProxies",
+		},
+		"trusted proxy": {
+			"templates/statefulset.yaml",
+			map[string]string{"haproxy.clientIP.header": "X-Forwarded-For", "haproxy.clientIP.trustedProxies": "{0.0.0.0/0,any}"},
+			"The haproxy.clientIP.trustedProxies entry any must be an IP address or a CIDR.",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.setValues["haproxy.enabled"] = "true"
+			options := &helm.Options{
+				SetValues:      tc.setValues,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{tc.template})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
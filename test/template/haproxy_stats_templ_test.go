@@ -0,0 +1,97 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestTemplateHAProxyStatsAuth(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	setValues := map[string]string{
+		"haproxy.enabled":             "true",
+		"haproxy.stats.enabled":       "true",
+		"haproxy.stats.auth.enabled":  "true",
+		"haproxy.stats.auth.username": "stats-user",
+		"haproxy.stats.auth.password": "stats-password",
+	}
+	options := &helm.Options{
+		SetValues:      setValues,
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify HAProxy expands the credentials from its environment
+	config := renderHAProxyConfig(t, setValues)
+	stats := config.Section("frontend", "stats")
+	require.NotNil(t, stats)
+	require.Equal(t, [][]string{{"stats", "auth", "${HAPROXY_STATS_USERNAME}:${HAPROXY_STATS_PASSWORD}"}}, stats.Find("stats", "auth"))
+
+	// Verify the chart keeps the credentials of the values in a Secret
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/secret-haproxy-stats.yaml"})
+	var secret corev1.Secret
+	helm.UnmarshalK8SYaml(t, output, &secret)
+	require.Equal(t, "ml-haproxy-stats", secret.Name)
+	require.Equal(t, "stats-user", string(secret.Data["username"]))
+	require.Equal(t, "stats-password", string(secret.Data["password"]))
+
+	// Verify the HAProxy pods read the Secret into the variables of the configuration
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/deployment.yaml"})
+	var deployment appsv1.Deployment
+	helm.UnmarshalK8SYaml(t, output, &deployment)
+	keys := map[string]string{}
+	for _, env := range deployment.Spec.Template.Spec.Containers[0].Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			require.Equal(t, "ml-haproxy-stats", env.ValueFrom.SecretKeyRef.Name)
+			keys[env.Name] = env.ValueFrom.SecretKeyRef.Key
+		}
+	}
+	require.Equal(t, map[string]string{"HAPROXY_STATS_USERNAME": "username", "HAPROXY_STATS_PASSWORD": "password"}, keys)
+
+	// Verify no Secret is rendered when the credentials come from an existing Secret, which the HAProxy pods read
+	delete(options.SetValues, "haproxy.stats.auth.username")
+	delete(options.SetValues, "haproxy.stats.auth.password")
+	options.SetValues["haproxy.stats.auth.secretName"] = "stats-credentials"
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/secret-haproxy-stats.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not find template")
+	helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	output = helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/deployment.yaml"})
+	helm.UnmarshalK8SYaml(t, output, &deployment)
+	for _, env := range deployment.Spec.Template.Spec.Containers[0].Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			require.Equal(t, "stats-credentials", env.ValueFrom.SecretKeyRef.Name)
+		}
+	}
+}
+
+func TestTemplateHAProxyStatsAuthValidation(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"haproxy.enabled":             "true",
+			"haproxy.stats.enabled":       "true",
+			"haproxy.stats.auth.enabled":  "true",
+			"haproxy.stats.auth.username": "stats-user",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "haproxy.stats.auth.username and haproxy.stats.auth.password must be set together")
+
+	// Verify the chart refuses an empty basic auth
+	delete(options.SetValues, "haproxy.stats.auth.username")
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "haproxy.stats.auth.enabled requires haproxy.stats.auth.username and haproxy.stats.auth.password")
+}
@@ -0,0 +1,217 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func odbcPortValues() map[string]string {
+	return map[string]string{
+		"haproxy.enabled":                "true",
+		"haproxy.tcpports.enabled":       "true",
+		"haproxy.tcpports.ports[0].name": "odbc",
+		"haproxy.tcpports.ports[0].type": "TCP",
+		"haproxy.tcpports.ports[0].port": "5432",
+	}
+}
+
+func TestTemplateHAProxyTCPPortTLSTermination(t *testing.T) {
+	values := odbcPortValues()
+	values["haproxy.tls.enabled"] = "true"
+	values["haproxy.tls.certFileName"] = "haproxy.pem"
+	values["haproxy.tcpports.ports[0].tls.mode"] = "terminate"
+	values["haproxy.tcpports.ports[1].name"] = "odbc-tls"
+	values["haproxy.tcpports.ports[1].type"] = "TCP"
+	values["haproxy.tcpports.ports[1].port"] = "5433"
+	values["haproxy.tcpports.ports[1].tls.mode"] = "terminate"
+	values["tls.enableOnDefaultAppServers"] = "true"
+	values["tls.appServers[0].name"] = "odbc-tls"
+	values["tls.appServers[0].port"] = "5433"
+	values["haproxy.backendTls.verify"] = "true"
+	config := renderHAProxyConfig(t, values)
+
+	// Verify HAProxy decrypts the connections with its certificate
+	for _, port := range []string{"5432", "5433"} {
+		listen := config.Section("listen", "marklogic-TCP-"+port)
+		require.NotNil(t, listen, port)
+		require.Equal(t, [][]string{{"bind", ":" + port, "ssl", "crt", "/usr/local/etc/ssl/haproxy.pem"}}, listen.Find("bind"), port)
+	}
+
+	// Verify HAProxy connects in plain TCP to an App Server without TLS
+	for _, server := range config.Section("listen", "marklogic-TCP-5432").Servers() {
+		_, ssl := server.Option("ssl")
+		require.False(t, ssl, server.Name)
+	}
+
+	// Verify HAProxy encrypts the connections again to an App Server with TLS and checks its certificate
+	servers := config.Section("listen", "marklogic-TCP-5433").Servers()
+	require.NotEmpty(t, servers)
+	for _, server := range servers {
+		verify, _ := server.Option("verify")
+		require.Equal(t, "required", verify, server.Name)
+		host, _ := server.Option("verifyhost")
+		require.Equal(t, server.Address[:len(server.Address)-len(":5433")], host, server.Name)
+	}
+}
+
+func TestTemplateHAProxyTCPPortSNI(t *testing.T) {
+	values := odbcPortValues()
+	values["haproxy.tcpports.sniPort"] = "5443"
+	values["haproxy.tcpports.ports[0].tls.mode"] = "passthrough"
+	values["haproxy.tcpports.ports[0].tls.sni"] = "{odbc.example.com,sql.example.com}"
+	values["haproxy.tcpports.ports[1].name"] = "odbc-reports"
+	values["haproxy.tcpports.ports[1].type"] = "TCP"
+	values["haproxy.tcpports.ports[1].port"] = "5434"
+	values["haproxy.tcpports.ports[1].targetPort"] = "5433"
+	values["haproxy.tcpports.ports[1].tls.mode"] = "passthrough"
+	values["haproxy.tcpports.ports[1].tls.sni"] = "{reports.example.com}"
+	config := renderHAProxyConfig(t, values)
+
+	// Verify a passthrough port forwards the TLS connections to its server names unchanged
+	listen := config.Section("listen", "marklogic-TCP-5432")
+	require.NotNil(t, listen)
+	require.Equal(t, [][]string{{"bind", ":5432"}}, listen.Find("bind"))
+	require.Equal(t, [][]string{
+		{"tcp-request", "inspect-delay", "5s"},
+		{"tcp-request", "content", "reject", "unless", "{", "req_ssl_hello_type", "1", "}", "{", "req_ssl_sni", "-i", "odbc.example.com", "sql.example.com", "}"},
+	}, listen.Find("tcp-request"))
+	for _, server := range listen.Servers() {
+		_, ssl := server.Option("ssl")
+		require.False(t, ssl, server.Name)
+	}
+
+	// Verify the shared frontend routes the TLS connections to the port of their server name
+	frontend := config.Section("frontend", "marklogic-TCP-sni")
+	require.NotNil(t, frontend)
+	require.Equal(t, [][]string{{"bind", ":5443"}}, frontend.Find("bind"))
+	require.True(t, frontend.Has("mode", "tcp"))
+	require.Equal(t, [][]string{
+		{"use_backend", "marklogic-TCP-5432", "if", "{", "req_ssl_sni", "-i", "odbc.example.com", "sql.example.com", "}"},
+		{"use_backend", "marklogic-TCP-5433", "if", "{", "req_ssl_sni", "-i", "reports.example.com", "}"},
+	}, frontend.Find("use_backend"))
+
+	// Verify the service exposes the port of the shared frontend
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+	options := &helm.Options{
+		SetValues:      values,
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	output := helm.RenderTemplate(t, options, helmChartPath, "ml", []string{"charts/haproxy/templates/service.yaml"})
+	var service corev1.Service
+	helm.UnmarshalK8SYaml(t, output, &service)
+	ports := map[string]int32{}
+	for _, port := range service.Spec.Ports {
+		ports[port.Name] = port.TargetPort.IntVal
+	}
+	require.Equal(t, int32(5443), ports["tcp-sni"])
+}
+
+func TestTemplateHAProxyTCPPortHealthCheck(t *testing.T) {
+	values := odbcPortValues()
+	values["haproxy.tcpports.ports[0].healthCheck.type"] = "pgsql"
+	values["haproxy.tcpports.ports[0].healthCheck.user"] = "odbc-check"
+	values["haproxy.healthCheck.interval"] = "10s"
+	values["haproxy.tcpports.ports[1].name"] = "odbc-reports"
+	values["haproxy.tcpports.ports[1].type"] = "TCP"
+	values["haproxy.tcpports.ports[1].port"] = "5433"
+	config := renderHAProxyConfig(t, values)
+
+	// Verify HAProxy checks the ODBC App Server itself with a PostgreSQL startup message
+	listen := config.Section("listen", "marklogic-TCP-5432")
+	require.Equal(t, [][]string{{"option", "pgsql-check", "user", "odbc-check"}}, listen.Find("option", "pgsql-check"))
+	require.False(t, listen.Has("option", "httpchk"))
+	require.Equal(t, [][]string{{"default-server", "check", "inter", "10s", "rise", "2", "fall", "3", "agent-check", "agent-port", "7996", "agent-inter", "2s"}}, listen.Find("default-server"))
+	require.True(t, listen.Has("option", "redispatch"))
+
+	// Verify the other ports keep checking the HealthCheck App Server
+	reports := config.Section("listen", "marklogic-TCP-5433")
+	require.True(t, reports.Has("option", "httpchk"))
+	require.False(t, reports.Has("option", "pgsql-check"))
+	require.Equal(t, [][]string{{"default-server", "check", "port", "7997", "inter", "10s", "rise", "2", "fall", "3", "agent-check", "agent-port", "7996", "agent-inter", "2s"}}, reports.Find("default-server"))
+}
+
+func TestTemplateHAProxyTCPPortTimeout(t *testing.T) {
+	values := odbcPortValues()
+	values["haproxy.tcpports.ports[0].timeout.client"] = "1h"
+	values["haproxy.tcpports.ports[0].timeout.server"] = "1h"
+	values["haproxy.tcpports.ports[0].timeout.connect"] = "5000"
+	config := renderHAProxyConfig(t, values)
+
+	// Verify the long running queries of the port outlive the timeouts of the defaults section
+	require.Equal(t, [][]string{
+		{"timeout", "client", "1h"},
+		{"timeout", "connect", "5000"},
+		{"timeout", "server", "1h"},
+	}, config.Section("listen", "marklogic-TCP-5432").Find("timeout"))
+	require.True(t, config.Section("defaults", "").Has("timeout", "client", "600s"))
+}
+
+func TestTemplateHAProxyTCPPortDefaults(t *testing.T) {
+	config := renderHAProxyConfig(t, odbcPortValues())
+
+	// Verify a TCP port forwards plain connections with the defaults and no SNI frontend
+	listen := config.Section("listen", "marklogic-TCP-5432")
+	require.NotNil(t, listen)
+	require.Equal(t, [][]string{{"bind", ":5432"}}, listen.Find("bind"))
+	require.False(t, listen.Has("tcp-request"))
+	require.False(t, listen.Has("timeout"))
+	require.True(t, listen.Has("option", "httpchk"))
+	require.Nil(t, config.Section("frontend", "marklogic-TCP-sni"))
+}
+
+func TestTemplateHAProxyTCPPortValidation(t *testing.T) {
+	helmChartPath, err := filepath.Abs("../../charts")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		setValues map[string]string
+		message   string
+	}{
+		"tls mode": {
+			map[string]string{"haproxy.tcpports.ports[0].tls.mode": "reencrypt"},
+			"The tls.mode reencrypt of the TCP port odbc must be terminate or passthrough.",
+		},
+		"termination without certificate": {
+			map[string]string{"haproxy.tcpports.ports[0].tls.mode": "terminate"},
+			"The tls.mode terminate of the TCP port odbc requires haproxy.tls.enabled",
+		},
+		"sni without passthrough": {
+			map[string]string{"haproxy.tcpports.ports[0].tls.sni": "{odbc.example.com}"},
+			"The tls.sni of the TCP port odbc requires tls.mode passthrough.",
+		},
+		"health check type": {
+			map[string]string{"haproxy.tcpports.ports[0].healthCheck.type": "mysql"},
+			"The healthCheck.type mysql of the TCP port odbc must be http or pgsql.",
+		},
+		"timeout name": {
+			map[string]string{"haproxy.tcpports.ports[0].timeout.queue": "30s"},
+			"The timeout.queue of the TCP port odbc must be one of client, server or connect.",
+		},
+		"timeout value": {
+			map[string]string{"haproxy.tcpports.ports[0].timeout.client": "1 hour"},
+			"The timeout.client 1 hour of the TCP port odbc must be a number with an optional unit",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			values := odbcPortValues()
+			for key, value := range tc.setValues {
+				values[key] = value
+			}
+			options := &helm.Options{
+				SetValues:      values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, "ml", []string{"templates/configmap-haproxy.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.message)
+		})
+	}
+}
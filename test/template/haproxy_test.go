@@ -1,7 +1,9 @@
 package template_test
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -184,3 +186,47 @@ func TestTemplateTestHAproxyConfigmap(t *testing.T) {
 
 	}
 }
+
+func TestTemplateTestHAproxyMaxReplicas(t *testing.T) {
+
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "haproxy"
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		replicaCount string
+		maxReplicas  string
+		servers      int
+	}{
+		"default":                    {"3", "", 16},
+		"max replicas":               {"3", "5", 5},
+		"replicas above the maximum": {"3", "2", 3},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues: map[string]string{
+					"haproxy.enabled": "true",
+					"replicaCount":    tc.replicaCount,
+				},
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			if tc.maxReplicas != "" {
+				options.SetValues["haproxy.maxReplicas"] = tc.maxReplicas
+			}
+
+			var configmap corev1.ConfigMap
+			output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+			helm.UnmarshalK8SYaml(t, output, &configmap)
+			config := configmap.Data["haproxy.cfg"]
+
+			// Verify every ordinal up to the maximum has a server that waits for the DNS name of its pod
+			require.Equal(t, tc.servers, strings.Count(config, "server haproxy-appservices-"))
+			for i := 0; i < tc.servers; i++ {
+				server := fmt.Sprintf("server haproxy-appservices-%d haproxy-%d.haproxy.marklogic-templ.svc.cluster.local:8000 resolvers dns init-addr none cookie haproxy-appservices-%d", i, i, i)
+				require.Contains(t, config, server)
+			}
+			require.NotContains(t, config, fmt.Sprintf("haproxy-%d.haproxy", tc.servers))
+		})
+	}
+}
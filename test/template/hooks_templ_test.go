@@ -0,0 +1,169 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplatePostBootstrapHooks(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "hooks"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":                 "progressofficial/marklogic-db",
+			"image.tag":                        "latest",
+			"persistence.enabled":              "false",
+			"hooks.postBootstrap[0].name":      "create-roles",
+			"hooks.postBootstrap[0].configMap": "marklogic-hooks",
+			"hooks.postBootstrap[0].key":       "roles.json",
+			"hooks.postBootstrap[0].type":      "manage",
+			"hooks.postBootstrap[0].path":      "/manage/v2/roles",
+			"hooks.postBootstrap[1].name":      "update-group",
+			"hooks.postBootstrap[1].configMap": "marklogic-hooks",
+			"hooks.postBootstrap[1].key":       "group.json",
+			"hooks.postBootstrap[1].type":      "manage",
+			"hooks.postBootstrap[1].method":    "put",
+			"hooks.postBootstrap[1].path":      "/manage/v2/groups/Default/properties",
+			"hooks.postBootstrap[2].name":      "create-triggers",
+			"hooks.postBootstrap[2].configMap": "trigger-hooks",
+			"hooks.postBootstrap[2].key":       "triggers.sh",
+			"hooks.postBootstrap[2].type":      "script",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+
+	// Verify the hooks are listed in order
+	expectedConf := "create-roles|manage|POST|/manage/v2/roles\n" +
+		"update-group|manage|PUT|/manage/v2/groups/Default/properties\n" +
+		"create-triggers|script|POST|\n"
+	require.Equal(t, expectedConf, configmap.Data["post-bootstrap-hooks.conf"])
+
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	// Verify each hook is mounted under its name from its ConfigMap
+	var hooksVolume *corev1.Volume
+	for i, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "post-bootstrap-hooks" {
+			hooksVolume = &statefulset.Spec.Template.Spec.Volumes[i]
+		}
+	}
+	require.NotNil(t, hooksVolume)
+	sources := hooksVolume.Projected.Sources
+	require.Len(t, sources, 3)
+	require.Equal(t, "marklogic-hooks", sources[0].ConfigMap.Name)
+	require.Equal(t, corev1.KeyToPath{Key: "roles.json", Path: "create-roles"}, sources[0].ConfigMap.Items[0])
+	require.Equal(t, "trigger-hooks", sources[2].ConfigMap.Name)
+	require.Equal(t, corev1.KeyToPath{Key: "triggers.sh", Path: "create-triggers"}, sources[2].ConfigMap.Items[0])
+
+	mounted := false
+	for _, mount := range statefulset.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "post-bootstrap-hooks" {
+			mounted = true
+			require.Equal(t, "/tmp/post-bootstrap-hooks", mount.MountPath)
+		}
+	}
+	require.True(t, mounted)
+}
+
+func TestChartTemplateNoPostBootstrapHooks(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "hooks"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	options := &helm.Options{
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		require.NotEqual(t, "post-bootstrap-hooks", volume.Name)
+	}
+
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Empty(t, configmap.Data["post-bootstrap-hooks.conf"])
+}
+
+func TestChartTemplatePostBootstrapHooksValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "hooks"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	hook := func(name string, hookType string, path string) map[string]string {
+		return map[string]string{
+			"hooks.postBootstrap[0].name":      name,
+			"hooks.postBootstrap[0].configMap": "marklogic-hooks",
+			"hooks.postBootstrap[0].key":       "hook",
+			"hooks.postBootstrap[0].type":      hookType,
+			"hooks.postBootstrap[0].path":      path,
+		}
+	}
+
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"invalid name":      {hook("Create_Roles", "script", ""), `The post-bootstrap hook name "Create_Roles" is invalid`},
+		"unknown type":      {hook("roles", "xquery", ""), `The post-bootstrap hook "roles" has type "xquery"`},
+		"missing path":      {hook("roles", "manage", ""), `The post-bootstrap hook "roles" of type manage must set a path`},
+		"missing ConfigMap": {map[string]string{"hooks.postBootstrap[0].name": "roles", "hooks.postBootstrap[0].type": "script"}, `The post-bootstrap hook "roles" must set configMap and key`},
+		"unknown policy":    {map[string]string{"hooks.failurePolicy": "Retry"}, `hooks.failurePolicy "Retry" is invalid`},
+	}
+	duplicate := hook("roles", "script", "")
+	duplicate["hooks.postBootstrap[1].name"] = "roles"
+	duplicate["hooks.postBootstrap[1].configMap"] = "marklogic-hooks"
+	duplicate["hooks.postBootstrap[1].key"] = "other"
+	duplicate["hooks.postBootstrap[1].type"] = "script"
+	tests["duplicate name"] = struct {
+		values        map[string]string
+		expectedError string
+	}{duplicate, `The post-bootstrap hook name "roles" is used more than once`}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestChartTemplateJoinCompatibilityAnnotations(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "dnode"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":           "false",
+			"tls.enableOnDefaultAppServers": "true",
+			"group.xdqpSsl.certSecretName":  "xdqp-cert",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify the StatefulSet records what a joining release must match
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	require.Equal(t, "true", statefulset.Annotations["marklogic.com/tls-enabled"])
+	require.Equal(t, "true", statefulset.Annotations["marklogic.com/xdqp-custom-certificate"])
+	require.Equal(t, "dnode-0.dnode.marklogic-templ.svc.cluster.local", statefulset.Annotations["marklogic.com/fqdn"])
+}
+
+func TestChartTemplateJoinCompatibilityClusterDomain(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "enode"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"bootstrapHostName": "dnode-0.dnode.marklogic-templ.svc.example.org",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bootstrapHostName dnode-0.dnode.marklogic-templ.svc.example.org is not in the cluster domain cluster.local of clusterDomain")
+
+	// Verify a bootstrap host in the cluster domain or outside of the cluster is accepted
+	for _, host := range []string{"dnode-0.dnode.marklogic-templ.svc.cluster.local", "marklogic.example.org"} {
+		options.SetValues["bootstrapHostName"] = host
+		_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,172 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+// requireNoLicenseInEnv verifies that no container of the StatefulSet gets license material in its environment
+func requireNoLicenseInEnv(t *testing.T, statefulset appsv1.StatefulSet, licenseValues ...string) {
+	containers := append(statefulset.Spec.Template.Spec.InitContainers, statefulset.Spec.Template.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			require.NotContains(t, []string{"LICENSE_KEY", "LICENSEE"}, env.Name, "container %s", container.Name)
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				require.NotContains(t, []string{"license-key", "licensee"}, env.ValueFrom.SecretKeyRef.Key, "container %s", container.Name)
+			}
+			for _, value := range licenseValues {
+				require.NotContains(t, env.Value, value, "container %s env %s", container.Name, env.Name)
+			}
+		}
+	}
+}
+
+func TestChartTemplateLicenseFromValues(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "license"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":    "progressofficial/marklogic-db",
+			"image.tag":           "latest",
+			"persistence.enabled": "false",
+			"license.key":         "Test License Key",
+			"license.licensee":    "Test Licensee",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/license-secret.yaml"})
+	var secret corev1.Secret
+	helm.UnmarshalK8SYaml(t, output, &secret)
+	require.Equal(t, releaseName+"-license", secret.Name)
+	require.Equal(t, "Test License Key", string(secret.Data["license-key"]))
+	require.Equal(t, "Test Licensee", string(secret.Data["licensee"]))
+
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	// Verify no license material appears in the StatefulSet env
+	requireNoLicenseInEnv(t, statefulset, "Test License Key", "Test Licensee")
+	require.NotContains(t, output, "Test License Key")
+
+	// Verify the license secret is mounted into the MarkLogic container and the license watcher
+	var licenseVolume *corev1.Volume
+	for i, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "license" {
+			licenseVolume = &statefulset.Spec.Template.Spec.Volumes[i]
+		}
+	}
+	require.NotNil(t, licenseVolume)
+	require.Equal(t, secret.Name, licenseVolume.Secret.SecretName)
+
+	containers := statefulset.Spec.Template.Spec.Containers
+	require.Len(t, containers, 2)
+	require.Equal(t, "license-watcher", containers[1].Name)
+	require.Equal(t, []string{"/bin/bash", "/tmp/helm-scripts/license-watcher.sh"}, containers[1].Command)
+	require.Equal(t, "LICENSE_UPDATE_INTERVAL", containers[1].Env[0].Name)
+	require.Equal(t, "60", containers[1].Env[0].Value)
+	for _, container := range containers {
+		mounted := false
+		for _, mount := range container.VolumeMounts {
+			if mount.Name == "license" {
+				mounted = true
+				require.Equal(t, "/run/secrets/ml-license", mount.MountPath)
+				require.True(t, mount.ReadOnly)
+			}
+		}
+		require.True(t, mounted, "license not mounted in %s", container.Name)
+	}
+}
+
+func TestChartTemplateLicenseFromSecret(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "license"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":       "progressofficial/marklogic-db",
+			"image.tag":              "latest",
+			"persistence.enabled":    "false",
+			"license.secretName":     "ml-license",
+			"license.updateInterval": "30",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// the chart does not create a secret when an existing one is referenced
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/license-secret.yaml"})
+	require.Error(t, err)
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	requireNoLicenseInEnv(t, statefulset)
+	found := false
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "license" {
+			found = true
+			require.Equal(t, "ml-license", volume.Secret.SecretName)
+		}
+	}
+	require.True(t, found)
+	require.Equal(t, "30", statefulset.Spec.Template.Spec.Containers[1].Env[0].Value)
+}
+
+func TestChartTemplateNoLicense(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "license"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled": "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	// Verify there is no license watcher or license volume without a license
+	require.Len(t, statefulset.Spec.Template.Spec.Containers, 1)
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		require.NotEqual(t, "license", volume.Name)
+	}
+}
@@ -0,0 +1,164 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplateStartupProbeEnabled(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "probes"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":    "progressofficial/marklogic-db",
+			"image.tag":           "latest",
+			"persistence.enabled": "false",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	// Verify the startup probe waits for the poststart hook to complete
+	container := statefulset.Spec.Template.Spec.Containers[0]
+	require.NotNil(t, container.StartupProbe)
+	require.Equal(t, []string{"test", "-f", "/tmp/poststart-completed"}, container.StartupProbe.Exec.Command)
+	require.EqualValues(t, 10, container.StartupProbe.PeriodSeconds)
+	require.EqualValues(t, 60, container.StartupProbe.FailureThreshold)
+
+	// Verify the liveness probe no longer needs a long initial delay
+	require.NotNil(t, container.LivenessProbe)
+	require.EqualValues(t, 30, container.LivenessProbe.InitialDelaySeconds)
+}
+
+func TestChartTemplateStartupProbeDisabled(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "probes"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"image.repository":                  "progressofficial/marklogic-db",
+			"image.tag":                         "latest",
+			"persistence.enabled":               "false",
+			"startupProbe.enabled":              "false",
+			"livenessProbe.initialDelaySeconds": "300",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+
+	// Verify only the liveness probe guards the bootstrap when the startup probe is disabled
+	container := statefulset.Spec.Template.Spec.Containers[0]
+	require.Nil(t, container.StartupProbe)
+	require.EqualValues(t, 300, container.LivenessProbe.InitialDelaySeconds)
+}
+
+func TestChartTemplateProbeTimingsValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "probes"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+
+	// startup probe that gives up before a bootstrap retry loop is exhausted
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"startupProbe.failureThreshold": "10",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Error(t, err, "Expected error due to short startup probe")
+	require.Contains(t, err.Error(), "The startup probe allows 110 seconds")
+
+	// liveness probe with a short delay and no startup probe to hold it off
+	options.SetValues = map[string]string{
+		"startupProbe.enabled":           "false",
+		"livenessProbe.failureThreshold": "10",
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Error(t, err, "Expected error due to short liveness probe without startup probe")
+	require.Contains(t, err.Error(), "the liveness probe allows 130 seconds")
+
+	// no probe can kill the pod during bootstrap
+	options.SetValues = map[string]string{
+		"startupProbe.enabled":  "false",
+		"livenessProbe.enabled": "false",
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.NoError(t, err)
+}
+
+func TestChartTemplateBootstrapRetryBudget(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "probes"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+
+	// Verify the scripts retry with the values the check is derived from
+	script := configmap.Data["poststart-hook.sh"]
+	for _, constant := range []string{"N_RETRY=10", "RETRY_INTERVAL=5", "JOIN_RETRIES=5", "GROUP_RETRIES=10", "JOIN_RETRY_INTERVAL=10", "TLS_PAUSE=5"} {
+		require.Contains(t, script, "\n"+constant+"\n")
+	}
+	require.Contains(t, configmap.Data["reconcile.sh"], `LOCAL_READY_RETRIES="${LOCAL_READY_RETRIES:-30}"`)
+
+	// Verify the probe must outlast the longest retry loop, the 30 waits of 5 seconds for the local host
+	options.SetValues = map[string]string{
+		"startupProbe.initialDelaySeconds": "0",
+		"startupProbe.failureThreshold":    "14",
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a retry loop of the bootstrap can wait up to 150 seconds")
+
+	options.SetValues["startupProbe.failureThreshold"] = "15"
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.NoError(t, err)
+}
@@ -0,0 +1,165 @@
+package template_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+func TestChartTemplateRolesAndUsers(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "security"
+	t.Log(helmChartPath, releaseName)
+	require.NoError(t, err)
+
+	// Set up the namespace; confirm that the template renders the expected value for the namespace.
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	t.Logf("Namespace: %s\n", namespaceName)
+
+	// Setup the args for helm install
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":                         "false",
+			"security.roles[0].name":                      "app-reader",
+			"security.roles[0].description":               "Reads the documents of the app",
+			"security.roles[0].roles[0]":                  "rest-reader",
+			"security.roles[0].privileges[0].name":        "xdmp:eval",
+			"security.roles[0].privileges[0].action":      "http://marklogic.com/xdmp/privileges/xdmp-eval",
+			"security.roles[0].permissions[0].role":       "app-reader",
+			"security.roles[0].permissions[0].capability": "read",
+			"security.roles[0].collections[0]":            "app",
+			"security.users[0].name":                      "app-service",
+			"security.users[0].passwordSecret.name":       "app-service-password",
+			"security.users[0].roles[0]":                  "app-reader",
+			"security.users[1].name":                      "batch@example.com",
+			"security.users[1].passwordSecret.name":       "batch-credentials",
+			"security.users[1].passwordSecret.key":        "secret",
+			"security.users[2].name":                      "auditor",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+
+	// Verify the payloads of the roles and users, without passwords
+	require.Equal(t, `app-reader|{"collection":["app"],"description":"Reads the documents of the app",`+
+		`"permission":[{"capability":"read","role-name":"app-reader"}],`+
+		`"privilege":[{"action":"http://marklogic.com/xdmp/privileges/xdmp-eval","kind":"execute","privilege-name":"xdmp:eval"}],`+
+		`"role":["rest-reader"],"role-name":"app-reader"}`+"\n", configmap.Data["security-roles.conf"])
+	require.Equal(t, `app-service|{"collection":[],"description":"","permission":[],"role":["app-reader"],"user-name":"app-service"}`+"\n"+
+		`batch@example.com|{"collection":[],"description":"","permission":[],"role":[],"user-name":"batch@example.com"}`+"\n"+
+		`auditor|{"collection":[],"description":"","permission":[],"role":[],"user-name":"auditor"}`+"\n",
+		configmap.Data["security-users.conf"])
+
+	// Verify the password secrets are projected into the MarkLogic container, one file per user
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	var passwords *corev1.Volume
+	for i, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "user-passwords" {
+			passwords = &statefulset.Spec.Template.Spec.Volumes[i]
+		}
+	}
+	require.NotNil(t, passwords)
+	sources := passwords.Projected.Sources
+	require.Len(t, sources, 2)
+	require.Equal(t, "app-service-password", sources[0].Secret.Name)
+	require.Equal(t, []corev1.KeyToPath{{Key: "password", Path: "app-service"}}, sources[0].Secret.Items)
+	require.Equal(t, "batch-credentials", sources[1].Secret.Name)
+	require.Equal(t, []corev1.KeyToPath{{Key: "secret", Path: "batch@example.com"}}, sources[1].Secret.Items)
+	mounted := false
+	for _, mount := range statefulset.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "user-passwords" {
+			mounted = true
+			require.Equal(t, "/run/secrets/ml-users", mount.MountPath)
+			require.True(t, mount.ReadOnly)
+		}
+	}
+	require.True(t, mounted)
+}
+
+func TestChartTemplateNoRolesAndUsers(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "security"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	options := &helm.Options{
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// render the tempate
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Empty(t, configmap.Data["security-roles.conf"])
+	require.Empty(t, configmap.Data["security-users.conf"])
+
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		require.NotEqual(t, "user-passwords", volume.Name)
+	}
+}
+
+func TestChartTemplateRolesAndUsersValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "security"
+	require.NoError(t, err)
+
+	namespaceName := "ml-" + strings.ToLower(random.UniqueId())
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"invalid role name": {map[string]string{"security.roles[0].name": "app reader"}, `The role name "app reader" is invalid`},
+		"missing user name": {map[string]string{"security.users[0].roles[0]": "app-reader"}, `The user name "" is invalid`},
+		"duplicate user": {map[string]string{
+			"security.users[0].name": "app-service",
+			"security.users[1].name": "app-service",
+		}, `The user name "app-service" is used more than once`},
+		"privilege without action": {map[string]string{
+			"security.roles[0].name":               "app-reader",
+			"security.roles[0].privileges[0].name": "xdmp:eval",
+		}, `The privileges of role "app-reader" must set name, action and a kind of execute or uri`},
+		"unknown capability": {map[string]string{
+			"security.users[0].name":                      "app-service",
+			"security.users[0].permissions[0].role":       "app-reader",
+			"security.users[0].permissions[0].capability": "write",
+		}, `The permissions of user "app-service" must set role and a capability of read, update, insert, execute or node-update`},
+		"password secret without name": {map[string]string{
+			"security.users[0].name":               "app-service",
+			"security.users[0].passwordSecret.key": "password",
+		}, `The passwordSecret of user "app-service" must set name`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
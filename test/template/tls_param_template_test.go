@@ -3,10 +3,12 @@ package template_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 
 	"github.com/gruntwork-io/terratest/modules/helm"
 	"github.com/gruntwork-io/terratest/modules/k8s"
@@ -108,3 +110,341 @@ func TestChartTemplateTLSDisabled(t *testing.T) {
 	numinit := len(statefulset.Spec.Template.Spec.InitContainers)
 	require.Equal(t, 0, numinit)
 }
+
+func TestChartTemplateTLSOnAppServers(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	namespaceName := "marklogic-templ"
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":                        "false",
+			"replicaCount":                               "2",
+			"tls.enableOnDefaultAppServers":              "true",
+			"tls.appServers[0].name":                     "app",
+			"tls.appServers[0].port":                     "8010",
+			"tls.appServers[1].name":                     "jobs",
+			"tls.appServers[1].port":                     "8011",
+			"tls.appServers[1].group":                    "enode",
+			"tls.appServers[1].certificateTemplate":      "corp",
+			"tls.appServers[1].clientCertRequired":       "true",
+			"tls.clientAuth.caSecretName":                "client-ca",
+			"haproxy.enabled":                            "true",
+			"haproxy.additionalAppServers[0].name":       "app",
+			"haproxy.additionalAppServers[0].type":       "HTTP",
+			"haproxy.additionalAppServers[0].port":       "8010",
+			"haproxy.additionalAppServers[0].path":       "/app",
+			"haproxy.additionalAppServers[1].name":       "plain",
+			"haproxy.additionalAppServers[1].type":       "HTTP",
+			"haproxy.additionalAppServers[1].port":       "8012",
+			"haproxy.additionalAppServers[1].targetPort": "8012",
+			"haproxy.additionalAppServers[1].path":       "/plain",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", namespaceName),
+	}
+
+	// Verify the TLS configuration of each App Server, in its group
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Equal(t, `app|Default|{"ssl-certificate-template":"defaultTemplate","ssl-require-client-certificate":false}`+"\n"+
+		`jobs|enode|{"ssl-certificate-template":"corp","ssl-require-client-certificate":true}`+"\n",
+		configmap.Data["app-server-tls.conf"])
+
+	// Verify HAProxy connects with TLS only to the App Servers with TLS, in both routing modes
+	for _, pathBased := range []string{"true", "false"} {
+		options.SetValues["haproxy.pathbased.enabled"] = pathBased
+		output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+		helm.UnmarshalK8SYaml(t, output, &configmap)
+		servers := map[string]string{}
+		for _, line := range strings.Split(configmap.Data["haproxy.cfg"], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 2 && fields[0] == "server" {
+				servers[fields[1]] = line
+			}
+		}
+		for _, name := range []string{"marklogic-manage-1", "ml-marklogic-8010-0", "ml-marklogic-8010-1"} {
+			require.Contains(t, servers[name], " ssl verify required ca-file /usr/local/etc/marklogic-ca/ca.pem", name)
+		}
+		for _, name := range []string{"ml-marklogic-8012-0", "ml-marklogic-8012-1"} {
+			require.NotContains(t, servers[name], " ssl", name)
+		}
+	}
+}
+
+func TestChartTemplateTLSOnAppServersValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+	clientAuth := func(values map[string]string) map[string]string {
+		result := clientAuthValues()
+		for key, value := range values {
+			result[key] = value
+		}
+		return result
+	}
+
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"without TLS on the default App Servers": {map[string]string{
+			"tls.appServers[0].name": "app",
+			"tls.appServers[0].port": "8010",
+		}, "tls.appServers requires tls.enableOnDefaultAppServers to be true"},
+		"missing port": {map[string]string{
+			"tls.enableOnDefaultAppServers": "true",
+			"tls.appServers[0].name":        "app",
+		}, "Each of tls.appServers must set name and port"},
+		"client certificates without client CA": {map[string]string{
+			"tls.enableOnDefaultAppServers":        "true",
+			"tls.appServers[0].name":               "app",
+			"tls.appServers[0].port":               "8010",
+			"tls.appServers[0].clientCertRequired": "true",
+		}, "The App Server app requires client certificates, which requires tls.clientAuth.caSecretName"},
+		"certificate authentication without client certificates": {map[string]string{
+			"tls.enableOnDefaultAppServers":    "true",
+			"tls.appServers[0].name":           "app",
+			"tls.appServers[0].port":           "8010",
+			"tls.appServers[0].authentication": "certificate",
+		}, "The App Server app uses certificate authentication, which requires clientCertRequired"},
+		"client certificates with path based routing": {clientAuth(map[string]string{
+			"haproxy.pathbased.enabled": "true",
+		}), "The App Server on port 8010 requires client certificates, which HAProxy can not verify with path based routing"},
+		"unknown HAProxy client auth mode": {clientAuth(map[string]string{
+			"haproxy.clientAuth.mode": "forward",
+		}), `haproxy.clientAuth.mode is "forward". It must be passthrough or terminate`},
+		"terminate without HAProxy TLS": {clientAuth(map[string]string{
+			"haproxy.clientAuth.mode": "terminate",
+		}), "haproxy.clientAuth.mode terminate requires haproxy.tls.enabled to be true"},
+		"duplicate App Server": {map[string]string{
+			"tls.enableOnDefaultAppServers": "true",
+			"tls.appServers[0].name":        "app",
+			"tls.appServers[0].port":        "8010",
+			"tls.appServers[1].name":        "app",
+			"tls.appServers[1].port":        "8010",
+		}, "The App Server app is listed more than once in tls.appServers"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+// clientAuthValues enables client certificate authentication on the App Server app on port 8010, routed by HAProxy
+func clientAuthValues() map[string]string {
+	return map[string]string{
+		"persistence.enabled":                        "false",
+		"tls.enableOnDefaultAppServers":              "true",
+		"tls.appServers[0].name":                     "app",
+		"tls.appServers[0].port":                     "8010",
+		"tls.appServers[0].clientCertRequired":       "true",
+		"tls.appServers[0].authentication":           "certificate",
+		"tls.clientAuth.caSecretName":                "client-ca",
+		"haproxy.enabled":                            "true",
+		"haproxy.additionalAppServers[0].name":       "app",
+		"haproxy.additionalAppServers[0].type":       "HTTP",
+		"haproxy.additionalAppServers[0].port":       "8010",
+		"haproxy.additionalAppServers[0].targetPort": "8010",
+	}
+}
+
+func TestChartTemplateClientCertificates(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues:      clientAuthValues(),
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify the App Server requires client certificates and authenticates users with them
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Equal(t, `app|Default|{"authentication":"certificate","ssl-certificate-template":"defaultTemplate","ssl-require-client-certificate":true}`+"\n",
+		configmap.Data["app-server-tls.conf"])
+
+	// Verify the client CA secret is mounted into the MarkLogic container
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	found := false
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "client-ca" {
+			found = true
+			require.Equal(t, "client-ca", volume.Secret.SecretName)
+		}
+	}
+	require.True(t, found)
+	mounted := false
+	for _, mount := range statefulset.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "client-ca" {
+			mounted = true
+			require.Equal(t, "/run/secrets/ml-client-ca", mount.MountPath)
+		}
+	}
+	require.True(t, mounted)
+
+	// Verify HAProxy forwards the TLS connections unchanged in passthrough mode
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	cfg := configmap.Data["haproxy.cfg"]
+	require.Contains(t, cfg, "listen marklogic-8010\n  bind :8010\n  mode tcp\n")
+	require.Contains(t, cfg, "server ml-marklogic-8010-0 marklogic-0.marklogic.marklogic-templ.svc.cluster.local:8010 check resolvers dns init-addr none")
+	require.NotContains(t, cfg, "frontend marklogic-8010")
+
+	// Verify HAProxy verifies the client certificates and presents its own in terminate mode
+	options.SetValues["haproxy.clientAuth.mode"] = "terminate"
+	options.SetValues["haproxy.tls.enabled"] = "true"
+	options.SetValues["haproxy.tls.secretName"] = "haproxy-cert"
+	options.SetValues["haproxy.tls.certFileName"] = "haproxy.pem"
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	cfg = configmap.Data["haproxy.cfg"]
+	require.Contains(t, cfg, "bind :8010 ssl crt /usr/local/etc/ssl/haproxy.pem ca-file /usr/local/etc/client-auth/ca.pem verify required\n")
+	require.Contains(t, cfg, "http-request set-header X-SSL-Client-DN %{+Q}[ssl_c_s_dn]\n")
+	require.Contains(t, cfg, "cookie marklogic-8010-0 ssl verify required ca-file /usr/local/etc/marklogic-ca/ca.pem sni str(marklogic-0.marklogic.marklogic-templ.svc.cluster.local) verifyhost marklogic-0.marklogic.marklogic-templ.svc.cluster.local crt /usr/local/etc/client-auth/client.pem")
+	require.NotContains(t, cfg, "listen marklogic-8010")
+}
+
+func TestChartTemplateTLSPolicy(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	ecdhe := "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:" +
+		"ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305"
+	tests := map[string]struct {
+		values     map[string]string
+		minVersion string
+		ciphers    string
+		disabled   string
+	}{
+		"modern": {map[string]string{"tls.policy": "modern"}, "TLSv1.3", ecdhe,
+			`"ssl-disable-sslv3":true,"ssl-disable-tlsv1":true,"ssl-disable-tlsv1-1":true,"ssl-disable-tlsv1-2":true`},
+		"intermediate": {map[string]string{"tls.policy": "intermediate"}, "TLSv1.2",
+			ecdhe + ":DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305",
+			`"ssl-disable-sslv3":true,"ssl-disable-tlsv1":true,"ssl-disable-tlsv1-1":true,"ssl-disable-tlsv1-2":false`},
+		"custom": {map[string]string{
+			"tls.policy":                  "custom",
+			"tls.customPolicy.minVersion": "TLSv1.1",
+			"tls.customPolicy.ciphers":    "HIGH:!aNULL",
+		}, "TLSv1.1", "HIGH:!aNULL",
+			`"ssl-disable-sslv3":true,"ssl-disable-tlsv1":true,"ssl-disable-tlsv1-1":false,"ssl-disable-tlsv1-2":false`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			values := map[string]string{
+				"persistence.enabled":           "false",
+				"tls.enableOnDefaultAppServers": "true",
+				"tls.appServers[0].name":        "app",
+				"tls.appServers[0].port":        "8010",
+				"haproxy.enabled":               "true",
+			}
+			for key, value := range tc.values {
+				values[key] = value
+			}
+			options := &helm.Options{
+				SetValues:      values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+
+			// Verify the policy is applied to tls.appServers and to the default App Servers, Manage last
+			output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+			var configmap corev1.ConfigMap
+			helm.UnmarshalK8SYaml(t, output, &configmap)
+			policy := `"ssl-ciphers":"` + tc.ciphers + `",` + tc.disabled
+			require.Equal(t, `app|Default|{"ssl-certificate-template":"defaultTemplate",`+policy+`,"ssl-require-client-certificate":false}`+"\n"+
+				`App-Services|Default|{"ssl-certificate-template":"defaultTemplate",`+policy+"}\n"+
+				`Admin|Default|{"ssl-certificate-template":"defaultTemplate",`+policy+"}\n"+
+				`Manage|Default|{"ssl-certificate-template":"defaultTemplate",`+policy+"}\n",
+				configmap.Data["app-server-tls.conf"])
+
+			// Verify HAProxy applies the same policy to its binds and to its connections to MarkLogic
+			output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+			helm.UnmarshalK8SYaml(t, output, &configmap)
+			require.Contains(t, configmap.Data["haproxy.cfg"], "global\n  log stdout format raw local0\n  maxconn 1024\n"+
+				"  ssl-default-bind-ciphers "+tc.ciphers+"\n"+
+				"  ssl-default-bind-options ssl-min-ver "+tc.minVersion+"\n"+
+				"  ssl-default-server-ciphers "+tc.ciphers+"\n"+
+				"  ssl-default-server-options ssl-min-ver "+tc.minVersion+"\n")
+		})
+	}
+}
+
+func TestChartTemplateNoTLSPolicy(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"tls.enableOnDefaultAppServers": "true",
+			"haproxy.enabled":               "true",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify MarkLogic and HAProxy keep their defaults
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.Empty(t, configmap.Data["app-server-tls.conf"])
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-haproxy.yaml"})
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+	require.NotContains(t, configmap.Data["haproxy.cfg"], "ssl-default-")
+}
+
+func TestChartTemplateTLSPolicyValidation(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		values        map[string]string
+		expectedError string
+	}{
+		"unknown policy": {map[string]string{"tls.policy": "old"},
+			`tls.policy is "old". It must be modern, intermediate or custom`},
+		"custom without ciphers": {map[string]string{"tls.policy": "custom"},
+			"tls.policy custom requires tls.customPolicy.ciphers"},
+		"custom with unknown version": {map[string]string{
+			"tls.policy":                  "custom",
+			"tls.customPolicy.minVersion": "SSLv3",
+			"tls.customPolicy.ciphers":    "HIGH",
+		}, `tls.customPolicy.minVersion is "SSLv3". It must be TLSv1.0, TLSv1.1, TLSv1.2 or TLSv1.3`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := &helm.Options{
+				SetValues:      tc.values,
+				KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+			}
+			_, err := helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
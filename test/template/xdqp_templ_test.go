@@ -0,0 +1,77 @@
+package template_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+func TestChartTemplateXdqpCertificate(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"persistence.enabled":          "false",
+			"group.xdqpSsl.certSecretName": "xdqp-cert",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+
+	// Verify the XDQP certificate secret is mounted into the MarkLogic container
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	var statefulset appsv1.StatefulSet
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	found := false
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		if volume.Name == "xdqp-cert" {
+			found = true
+			require.Equal(t, "xdqp-cert", volume.Secret.SecretName)
+		}
+	}
+	require.True(t, found)
+	mounted := false
+	for _, mount := range statefulset.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "xdqp-cert" {
+			mounted = true
+			require.Equal(t, "/run/secrets/ml-xdqp", mount.MountPath)
+			require.True(t, mount.ReadOnly)
+		}
+	}
+	require.True(t, mounted)
+
+	// Verify nothing is mounted without a custom certificate
+	delete(options.SetValues, "group.xdqpSsl.certSecretName")
+	output = helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	helm.UnmarshalK8SYaml(t, output, &statefulset)
+	for _, volume := range statefulset.Spec.Template.Spec.Volumes {
+		require.NotEqual(t, "xdqp-cert", volume.Name)
+	}
+}
+
+func TestChartTemplateXdqpCertificateWithoutXdqpSsl(t *testing.T) {
+
+	// Path to the helm chart we will test
+	helmChartPath, err := filepath.Abs("../../charts")
+	releaseName := "marklogic"
+	require.NoError(t, err)
+
+	options := &helm.Options{
+		SetValues: map[string]string{
+			"group.enableXdqpSsl":          "false",
+			"group.xdqpSsl.certSecretName": "xdqp-cert",
+		},
+		KubectlOptions: k8s.NewKubectlOptions("", "", "marklogic-templ"),
+	}
+	_, err = helm.RenderTemplateE(t, options, helmChartPath, releaseName, []string{"templates/statefulset.yaml"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "group.xdqpSsl.certSecretName requires group.enableXdqpSsl to be true")
+}
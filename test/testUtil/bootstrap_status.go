@@ -0,0 +1,81 @@
+// Package testUtil contains utility functions for all the tests in this repo
+package testUtil
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/k8s"
+)
+
+// BootstrapStatusAnnotation is the pod annotation the poststart hook writes the bootstrap status to
+const BootstrapStatusAnnotation = "marklogic.com/bootstrap-status"
+
+// BootstrapStatus is the bootstrap status reported by the poststart hook of a MarkLogic pod
+type BootstrapStatus struct {
+	Host      string            `json:"host"`
+	Group     string            `json:"group"`
+	Phase     string            `json:"phase"`
+	State     string            `json:"state"`
+	Message   string            `json:"message"`
+	Timestamp string            `json:"timestamp"`
+	Phases    map[string]string `json:"phases"`
+}
+
+// GetBootstrapStatus : testUtil function to read the bootstrap status annotation of a pod
+func GetBootstrapStatus(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string) (*BootstrapStatus, error) {
+	pod, err := k8s.GetPodE(t, kubectlOpt, podName)
+	if err != nil {
+		return nil, err
+	}
+	annotation, ok := pod.Annotations[BootstrapStatusAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	status := &BootstrapStatus{}
+	if err := json.Unmarshal([]byte(annotation), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// WaitUntilBootstrapPhase : testUtil function to wait until a bootstrap phase of a pod has succeeded or was skipped.
+// The phase "complete" waits for the whole bootstrap to finish. An error is returned as soon as the phase fails.
+func WaitUntilBootstrapPhase(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, phase string, retries int, interval time.Duration) (*BootstrapStatus, error) {
+	var status *BootstrapStatus
+	var err error
+	for i := 1; i <= retries; i++ {
+		status, err = GetBootstrapStatus(t, kubectlOpt, podName)
+		if err != nil || status == nil {
+			t.Logf("Bootstrap status of %s not available yet", podName)
+			time.Sleep(interval)
+			continue
+		}
+		state := status.Phases[phase]
+		if phase == "complete" {
+			state = "Pending"
+			if status.Phase == "complete" {
+				state = status.State
+			}
+		}
+		t.Logf("Bootstrap phase %s of %s: %s", phase, podName, state)
+		switch state {
+		case "Succeeded", "Skipped":
+			return status, nil
+		case "Failed":
+			return status, fmt.Errorf("bootstrap phase %s of %s failed: %s", phase, podName, status.Message)
+		}
+		time.Sleep(interval)
+	}
+	if err != nil {
+		return status, err
+	}
+	return status, fmt.Errorf("timed out waiting for bootstrap phase %s of %s", phase, podName)
+}
+
+// WaitUntilBootstrapCompleted : testUtil function to wait until the bootstrap of a pod has completed
+func WaitUntilBootstrapCompleted(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, retries int, interval time.Duration) (*BootstrapStatus, error) {
+	return WaitUntilBootstrapPhase(t, kubectlOpt, podName, "complete", retries, interval)
+}
@@ -0,0 +1,102 @@
+package testUtil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// CertificateAuthority is a CA generated for a test, able to issue server and client certificates
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	// CertPEM is the PEM encoded certificate of the CA, the content of a cacert.pem secret key
+	CertPEM []byte
+	key     *rsa.PrivateKey
+}
+
+// Certificate is a certificate issued by a CertificateAuthority with its private key
+type Certificate struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// TLSCertificate returns the certificate to present in a tls.Config
+func (c Certificate) TLSCertificate(t *testing.T) tls.Certificate {
+	certificate, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+	require.NoError(t, err)
+	return certificate
+}
+
+// PEM returns the certificate followed by its private key, the format HAProxy expects for crt files
+func (c Certificate) PEM() []byte {
+	return append(append([]byte{}, c.CertPEM...), c.KeyPEM...)
+}
+
+// NewCertificateAuthority generates a self-signed CA valid for a day
+func NewCertificateAuthority(t *testing.T, commonName string) *CertificateAuthority {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(t),
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"MarkLogic"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &CertificateAuthority{
+		Certificate: certificate,
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:         key,
+	}
+}
+
+// Issue returns a certificate signed by the CA for the common name, usable by clients and by servers
+// answering for the DNS names
+func (ca *CertificateAuthority) Issue(t *testing.T, commonName string, dnsNames ...string) Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(t),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"MarkLogic"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return Certificate{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// Pool returns a certificate pool trusting the CA
+func (ca *CertificateAuthority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
+
+func serialNumber(t *testing.T) *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	require.NoError(t, err)
+	return serial
+}
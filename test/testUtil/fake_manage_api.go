@@ -0,0 +1,162 @@
+// Package testUtil contains utility functions for all the tests in this repo
+package testUtil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RecordedRequest is a request received by the FakeManageAPI
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// FakeManageAPI is an in-memory stand-in for the MarkLogic Admin, Manage and REST APIs.
+// GET returns the body last stored for a path, PUT and POST store the request body,
+// DELETE removes it. Handlers registered with Handle take precedence.
+type FakeManageAPI struct {
+	Server *httptest.Server
+
+	mu        sync.Mutex
+	requests  []RecordedRequest
+	resources map[string]string
+	handlers  map[string]http.HandlerFunc
+}
+
+// NewFakeManageAPI : testUtil function to start a FakeManageAPI that is closed when the test ends
+func NewFakeManageAPI(t *testing.T) *FakeManageAPI {
+	f := &FakeManageAPI{
+		resources: map[string]string{},
+		handlers:  map[string]http.HandlerFunc{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Server.Close)
+	return f
+}
+
+// URL : testUtil function to get the base URL of the FakeManageAPI
+func (f *FakeManageAPI) URL() string {
+	return f.Server.URL
+}
+
+// Handle : testUtil function to register a handler for a method and path, for example "PUT", "/manage/v2/hosts"
+func (f *FakeManageAPI) Handle(method string, path string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[method+" "+path] = handler
+}
+
+// Respond : testUtil function to answer a method and path with a fixed status code and body
+func (f *FakeManageAPI) Respond(method string, path string, statusCode int, body string) {
+	f.Handle(method, path, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statusCode)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+// RestartOn : testUtil function to answer the timestamp service of the Admin API with a startup time, from
+// 2024-01-01T00:00:00Z, one day later after each request to a method and path, as MarkLogic restarts after it
+func (f *FakeManageAPI) RestartOn(method string, path string) {
+	f.Handle(http.MethodGet, "/admin/v1/timestamp", func(w http.ResponseWriter, _ *http.Request) {
+		startup := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, len(f.RequestsTo(method, path)))
+		_, _ = io.WriteString(w, startup.Format(time.RFC3339))
+	})
+}
+
+// SetResource : testUtil function to store the body returned by GET for a path
+func (f *FakeManageAPI) SetResource(path string, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resources[path] = body
+}
+
+// Resource : testUtil function to get the body stored for a path
+func (f *FakeManageAPI) Resource(path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.resources[path]
+	return body, ok
+}
+
+// Requests : testUtil function to get all requests received so far
+func (f *FakeManageAPI) Requests() []RecordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]RecordedRequest(nil), f.requests...)
+}
+
+// RequestsTo : testUtil function to get the requests received for a method and path
+func (f *FakeManageAPI) RequestsTo(method string, path string) []RecordedRequest {
+	var matched []RecordedRequest
+	for _, r := range f.Requests() {
+		if r.Method == method && r.Path == path {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// ModifyingRequests : testUtil function to get the requests that would change the configuration
+func (f *FakeManageAPI) ModifyingRequests() []RecordedRequest {
+	var matched []RecordedRequest
+	for _, r := range f.Requests() {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+func (f *FakeManageAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	})
+	handler, ok := f.handlers[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+
+	if ok {
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		handler(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		resource, found := f.resources[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, resource)
+	case http.MethodPut:
+		f.resources[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		f.resources[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(f.resources, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,198 @@
+package testUtil
+
+import (
+	"strings"
+	"testing"
+)
+
+// haproxySectionKinds are the keywords starting a section of an HAProxy configuration
+var haproxySectionKinds = map[string]bool{
+	"global":      true,
+	"defaults":    true,
+	"frontend":    true,
+	"backend":     true,
+	"listen":      true,
+	"resolvers":   true,
+	"peers":       true,
+	"userlist":    true,
+	"cache":       true,
+	"program":     true,
+	"http-errors": true,
+	"ring":        true,
+}
+
+// HAProxySection is a section of an HAProxy configuration with its directives split into words
+type HAProxySection struct {
+	Kind       string
+	Name       string
+	Directives [][]string
+}
+
+// HAProxyServer is a server line of an HAProxy section
+type HAProxyServer struct {
+	Name    string
+	Address string
+	Options []string
+}
+
+// HAProxyConfig is a parsed HAProxy configuration
+type HAProxyConfig struct {
+	Sections []*HAProxySection
+}
+
+// ParseHAProxyConfig splits an HAProxy configuration into its sections and directives.
+// Comments and blank lines are dropped, quoted words are unquoted.
+func ParseHAProxyConfig(t *testing.T, config string) *HAProxyConfig {
+	parsed := &HAProxyConfig{}
+	var section *HAProxySection
+	for number, line := range strings.Split(config, "\n") {
+		words, ok := splitHAProxyLine(line)
+		if !ok {
+			t.Fatalf("Unterminated quote on line %d of the HAProxy configuration: %s", number+1, line)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if haproxySectionKinds[words[0]] {
+			section = &HAProxySection{Kind: words[0]}
+			if len(words) > 1 {
+				section.Name = words[1]
+			}
+			parsed.Sections = append(parsed.Sections, section)
+			continue
+		}
+		if section == nil {
+			t.Fatalf("Directive outside of a section on line %d of the HAProxy configuration: %s", number+1, line)
+		}
+		section.Directives = append(section.Directives, words)
+	}
+	return parsed
+}
+
+// splitHAProxyLine splits a line into words, honoring quotes, backslash escapes and comments
+func splitHAProxyLine(line string) ([]string, bool) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			// keep the escape, HAProxy regular expressions rely on it
+			word.WriteRune(c)
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == '#':
+			if inWord {
+				words = append(words, word.String())
+			}
+			return words, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, true
+}
+
+// Section returns the section of a kind and name, nil if the configuration does not have it
+func (c *HAProxyConfig) Section(kind string, name string) *HAProxySection {
+	for _, section := range c.Sections {
+		if section.Kind == kind && section.Name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// SectionsOf returns the sections of a kind in the order of the configuration
+func (c *HAProxyConfig) SectionsOf(kind string) []*HAProxySection {
+	sections := []*HAProxySection{}
+	for _, section := range c.Sections {
+		if section.Kind == kind {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// Find returns the directives starting with the given words
+func (s *HAProxySection) Find(prefix ...string) [][]string {
+	found := [][]string{}
+	for _, directive := range s.Directives {
+		if len(directive) < len(prefix) {
+			continue
+		}
+		matches := true
+		for i, word := range prefix {
+			if directive[i] != word {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, directive)
+		}
+	}
+	return found
+}
+
+// Has reports whether the section has a directive starting with the given words
+func (s *HAProxySection) Has(prefix ...string) bool {
+	return len(s.Find(prefix...)) > 0
+}
+
+// Servers returns the server lines of the section
+func (s *HAProxySection) Servers() []HAProxyServer {
+	servers := []HAProxyServer{}
+	for _, directive := range s.Find("server") {
+		server := HAProxyServer{}
+		if len(directive) > 1 {
+			server.Name = directive[1]
+		}
+		if len(directive) > 2 {
+			server.Address = directive[2]
+			server.Options = directive[3:]
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// Option returns the value following an option of the server and whether the server has the option
+func (s HAProxyServer) Option(name string) (string, bool) {
+	for i, option := range s.Options {
+		if option == name {
+			if i+1 < len(s.Options) {
+				return s.Options[i+1], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,62 @@
+// Package testUtil contains utility functions for all the tests in this repo
+package testUtil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/helm"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// scriptPrelude replaces the logging helpers of poststart-hook.sh for scripts run outside a pod
+const scriptPrelude = `
+info() { echo "Info $*"; }
+log() { echo "$*"; }
+error() {
+    echo "Error $1"
+    LAST_ERROR="$1"
+    if [[ "$2" == "exit" ]]; then
+        exit 1
+    fi
+}
+N_RETRY=2
+RETRY_INTERVAL=0
+`
+
+// RenderHelmScripts : testUtil function to render the scripts ConfigMap of the chart and write each script to a temporary directory
+func RenderHelmScripts(t *testing.T, options *helm.Options, helmChartPath string, releaseName string) string {
+	output := helm.RenderTemplate(t, options, helmChartPath, releaseName, []string{"templates/configmap-scripts.yaml"})
+	var configmap corev1.ConfigMap
+	helm.UnmarshalK8SYaml(t, output, &configmap)
+
+	scriptsDir := t.TempDir()
+	for name, script := range configmap.Data {
+		if err := os.WriteFile(filepath.Join(scriptsDir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return scriptsDir
+}
+
+// RunHelmScript : testUtil function to run a bash script with the chart scripts available under HELM_SCRIPTS_PATH.
+// Returns the combined output of the script.
+func RunHelmScript(t *testing.T, scriptsDir string, env map[string]string, script string) (string, error) {
+	cmd := exec.Command("bash", "-c", scriptPrelude+script)
+	cmd.Dir = t.TempDir()
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + cmd.Dir,
+		"HELM_SCRIPTS_PATH=" + scriptsDir,
+		"ML_KUBERNETES_FILE_PATH=" + cmd.Dir,
+	}
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	output, err := cmd.CombinedOutput()
+	t.Log(strings.TrimSpace(string(output)))
+	return string(output), err
+}
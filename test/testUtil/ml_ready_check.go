@@ -14,7 +14,7 @@ import (
 // MLReadyCheck : testUtil function to check if MarkLogic is ready for e2e tests
 func MLReadyCheck(t *testing.T, kubectlOpt *k8s.KubectlOptions, podName string, tlsConfig *tls.Config) (bool, error) {
 
-	tunnel7997 := k8s.NewTunnel(kubectlOpt, k8s.ResourceTypePod, podName, 7997, 7997)
+	tunnel7997 := k8s.NewTunnel(kubectlOpt, k8s.ResourceTypePod, podName, 0, 7997)
 	defer tunnel7997.Close()
 	tunnel7997.ForwardPort(t)
 	endpoint7997 := fmt.Sprintf("http://%s/", tunnel7997.Endpoint())
@@ -3,7 +3,6 @@ package testUtil
 
 import (
 	"crypto/tls"
-	"strings"
 	"testing"
 	"time"
 
@@ -33,11 +32,9 @@ func RestartPodAndVerify(t *testing.T, delAtOnce bool, podList []string, namespa
 	// wait until the pod is in Ready status and MarkLogic server is ready
 	for _, pod := range podList {
 		k8s.WaitUntilPodAvailable(t, kubectlOpt, pod, 20, 15*time.Second)
-		if strings.HasSuffix(pod, "-0") {
-			_, err := MLReadyCheck(t, kubectlOpt, pod, tlsConfig)
-			if err != nil {
-				t.Fatal("MarkLogic failed to start")
-			}
+		_, err := MLReadyCheck(t, kubectlOpt, pod, tlsConfig)
+		if err != nil {
+			t.Fatalf("MarkLogic failed to start on %s", pod)
 		}
 	}
 }
@@ -0,0 +1,121 @@
+package mlconfig
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Action is what applying the bundle does to a resource
+type Action string
+
+const (
+	// Create is a resource of the bundle that does not exist in the cluster
+	Create Action = "create"
+	// Update is a resource whose properties in the cluster differ from the bundle
+	Update Action = "update"
+	// Unchanged is a resource that already matches the bundle
+	Unchanged Action = "unchanged"
+)
+
+// Change is the action planned for a resource of the bundle
+type Change struct {
+	Resource Resource
+	Action   Action
+	// Diffs are the properties that differ from the cluster, or all properties of a resource to create
+	Diffs []FieldDiff
+}
+
+// String formats the change as a line for the resource followed by an indented line per property
+func (c Change) String() string {
+	symbol := map[Action]string{Create: "+", Update: "~", Unchanged: "="}[c.Action]
+	lines := []string{fmt.Sprintf("%s %s %s (%s)", symbol, c.Action, c.Resource, c.Resource.File)}
+	for _, diff := range c.Diffs {
+		if c.Action == Create {
+			lines = append(lines, fmt.Sprintf("    %s: %s", diff.Field, formatValue(diff.Desired)))
+		} else {
+			lines = append(lines, "    "+diff.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Options configure how a bundle is applied
+type Options struct {
+	// DryRun only reports the changes, without sending anything that modifies the cluster
+	DryRun bool
+	// Out receives a line per resource and its changed properties
+	Out io.Writer
+}
+
+// Plan compares a resource of the bundle with the cluster
+func Plan(client *Client, resource Resource) (Change, error) {
+	current, found, err := client.Properties(resource.PropertiesPath())
+	if err != nil {
+		return Change{}, fmt.Errorf("%s: %w", resource, err)
+	}
+	if !found {
+		var diffs []FieldDiff
+		var fields []string
+		for field := range resource.Payload {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			diffs = append(diffs, FieldDiff{Field: field, Desired: resource.Payload[field]})
+		}
+		return Change{Resource: resource, Action: Create, Diffs: diffs}, nil
+	}
+	diffs := Compare(resource.Payload, current, resource.Kind.WriteOnly)
+	if len(diffs) == 0 {
+		return Change{Resource: resource, Action: Unchanged}, nil
+	}
+	return Change{Resource: resource, Action: Update, Diffs: diffs}, nil
+}
+
+// Apply creates the resources of the bundle that are missing and updates those that differ, in the order
+// of the bundle. Resources that already match are left alone, so applying a bundle again changes nothing.
+// It stops at the first resource that fails and returns the changes planned up to that point.
+func Apply(client *Client, bundle *Bundle, options Options) ([]Change, error) {
+	out := options.Out
+	if out == nil {
+		out = io.Discard
+	}
+	for _, skipped := range bundle.Skipped {
+		fmt.Fprintf(out, "! skipped %s, not supported\n", skipped)
+	}
+
+	var changes []Change
+	for _, resource := range bundle.Resources {
+		change, err := Plan(client, resource)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+		fmt.Fprintln(out, change)
+		if options.DryRun {
+			continue
+		}
+		switch change.Action {
+		case Create:
+			err = client.Send(http.MethodPost, resource.CollectionPath(), resource.Payload)
+		case Update:
+			err = client.Send(http.MethodPut, resource.PropertiesPath(), resource.Payload)
+		}
+		if err != nil {
+			return changes, fmt.Errorf("failed to %s %s: %w", change.Action, resource, err)
+		}
+	}
+	return changes, nil
+}
+
+// Summary counts the changes by action, for example "2 to create, 1 to update, 3 unchanged"
+func Summary(changes []Change) string {
+	counts := map[Action]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d unchanged", counts[Create], counts[Update], counts[Unchanged])
+}
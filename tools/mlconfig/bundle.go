@@ -0,0 +1,323 @@
+// Package mlconfig applies a MarkLogic configuration bundle, laid out like the ml-config directory of ml-gradle,
+// to a cluster through the Manage API.
+package mlconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kind describes a type of resource in the bundle and where it lives in the Manage API
+type Kind struct {
+	// Name of the kind used in messages, for example "database"
+	Name string
+	// Dir is the directory of the bundle holding the payloads, relative to the bundle root
+	Dir string
+	// Nested is true when the payloads are in subdirectories of Dir, like forests/<database>/<forest>.json
+	Nested bool
+	// Collection is the Manage API path used to create resources of this kind
+	Collection string
+	// NameField is the payload property holding the name of the resource
+	NameField string
+	// References are payload properties naming other resources of the same kind that must exist first
+	References []string
+	// WriteOnly are payload properties the Manage API never returns, so they are not compared
+	WriteOnly []string
+}
+
+// Kinds lists the supported kinds in the order they are applied: security objects, then groups,
+// then databases and their forests, then the app servers that use them.
+var Kinds = []Kind{
+	{Name: "privilege", Dir: "security/privileges", Collection: "/manage/v2/privileges", NameField: "privilege-name"},
+	{Name: "role", Dir: "security/roles", Collection: "/manage/v2/roles", NameField: "role-name", References: []string{"role"}},
+	{Name: "user", Dir: "security/users", Collection: "/manage/v2/users", NameField: "user-name", WriteOnly: []string{"password"}},
+	{Name: "group", Dir: "groups", Collection: "/manage/v2/groups", NameField: "group-name"},
+	{Name: "database", Dir: "databases", Collection: "/manage/v2/databases", NameField: "database-name",
+		References: []string{"security-database", "schema-database", "triggers-database"}},
+	{Name: "forest", Dir: "forests", Nested: true, Collection: "/manage/v2/forests", NameField: "forest-name"},
+	{Name: "server", Dir: "servers", Collection: "/manage/v2/servers", NameField: "server-name"},
+}
+
+// Resource is a single payload of the bundle
+type Resource struct {
+	Kind    Kind
+	Name    string
+	File    string
+	Payload map[string]interface{}
+}
+
+// String returns the kind and name of the resource, with the group for app servers
+func (r Resource) String() string {
+	if r.Kind.Name == "server" {
+		return fmt.Sprintf("server %s (group %s)", r.Name, r.group())
+	}
+	return r.Kind.Name + " " + r.Name
+}
+
+func (r Resource) group() string {
+	if group, ok := r.Payload["group-name"].(string); ok && group != "" {
+		return group
+	}
+	return "Default"
+}
+
+// query returns the query parameters that identify the resource besides its name
+func (r Resource) query() url.Values {
+	query := url.Values{}
+	switch r.Kind.Name {
+	case "privilege":
+		if kind, ok := r.Payload["kind"].(string); ok {
+			query.Set("kind", kind)
+		}
+	case "server":
+		query.Set("group-id", r.group())
+	}
+	return query
+}
+
+// CollectionPath returns the Manage API path used to create the resource
+func (r Resource) CollectionPath() string {
+	return withQuery(r.Kind.Collection, r.query())
+}
+
+// PropertiesPath returns the Manage API path used to read and update the properties of the resource
+func (r Resource) PropertiesPath() string {
+	return withQuery(r.Kind.Collection+"/"+url.PathEscape(r.Name)+"/properties", r.query())
+}
+
+func withQuery(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// Bundle is a configuration bundle loaded from disk, in the order it is applied
+type Bundle struct {
+	Resources []Resource
+	// Skipped lists the files and directories of the bundle that are not supported and are ignored
+	Skipped []string
+}
+
+// LoadBundle reads the payloads of a bundle directory and orders them so every resource comes after
+// the resources it depends on
+func LoadBundle(dir string) (*Bundle, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	bundle := &Bundle{}
+	known := map[string]bool{}
+	for _, kind := range Kinds {
+		known[kind.Dir] = true
+		resources, skipped, err := loadKind(dir, kind)
+		if err != nil {
+			return nil, err
+		}
+		ordered, err := orderByReferences(kind, resources)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Resources = append(bundle.Resources, ordered...)
+		bundle.Skipped = append(bundle.Skipped, skipped...)
+	}
+
+	// report directories of the ml-config layout that are not supported, so they are not silently ignored
+	err = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if hidden(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if known[rel] {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() || !isParentOfKnown(rel, known) {
+			bundle.Skipped = append(bundle.Skipped, rel)
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(bundle.Skipped)
+	return bundle, nil
+}
+
+// hidden reports whether a path of the bundle is hidden. ConfigMap volumes keep the actual files
+// in hidden ..data directories and link to them.
+func hidden(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func isParentOfKnown(rel string, known map[string]bool) bool {
+	for dir := range known {
+		if strings.HasPrefix(dir, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadKind reads the JSON payloads of a kind, sorted by file name
+func loadKind(root string, kind Kind) ([]Resource, []string, error) {
+	var files, skipped []string
+	dir := filepath.Join(root, filepath.FromSlash(kind.Dir))
+	pattern := filepath.Join(dir, "*")
+	if kind.Nested {
+		pattern = filepath.Join(dir, "*", "*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range matches {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		rel, _ := filepath.Rel(root, file)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case hidden(rel):
+			continue
+		case !info.IsDir() && strings.EqualFold(filepath.Ext(file), ".json"):
+			files = append(files, file)
+		default:
+			skipped = append(skipped, rel)
+		}
+	}
+	sort.Strings(files)
+
+	var resources []Resource
+	names := map[string]string{}
+	for _, file := range files {
+		rel, _ := filepath.Rel(root, file)
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := decodeObject(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		name, ok := payload[kind.NameField].(string)
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("%s: %s payload has no %s", rel, kind.Name, kind.NameField)
+		}
+		resource := Resource{Kind: kind, Name: name, File: rel, Payload: payload}
+		if previous, ok := names[resource.String()]; ok {
+			return nil, nil, fmt.Errorf("%s: %s is also defined in %s", rel, resource, previous)
+		}
+		names[resource.String()] = rel
+		resources = append(resources, resource)
+	}
+	return resources, skipped, nil
+}
+
+// decodeObject decodes a JSON object, keeping numbers as they are written so they compare by their text
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return object, nil
+}
+
+// orderByReferences sorts the resources of a kind so that resources referenced by others come first,
+// keeping the file order otherwise
+func orderByReferences(kind Kind, resources []Resource) ([]Resource, error) {
+	if len(kind.References) == 0 {
+		return resources, nil
+	}
+	byName := map[string]int{}
+	for i, resource := range resources {
+		byName[resource.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(resources))
+	var ordered []Resource
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("%ss reference each other in a cycle: %s", kind.Name, strings.Join(append(path, resources[i].Name), " -> "))
+		}
+		state[i] = visiting
+		for _, reference := range references(resources[i], kind.References) {
+			// references to resources outside the bundle must already exist in the cluster
+			if j, ok := byName[reference]; ok && j != i {
+				if err := visit(j, append(path, resources[i].Name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		ordered = append(ordered, resources[i])
+		return nil
+	}
+	for i := range resources {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// references returns the names held by the given payload properties, which are either a name or a list of names
+func references(resource Resource, fields []string) []string {
+	var names []string
+	for _, field := range fields {
+		switch value := resource.Payload[field].(type) {
+		case string:
+			names = append(names, value)
+		case []interface{}:
+			for _, item := range value {
+				if name, ok := item.(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return names
+}
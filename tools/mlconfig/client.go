@@ -0,0 +1,178 @@
+package mlconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// Client sends requests to the Manage API of a MarkLogic cluster
+type Client struct {
+	http     *req.Client
+	manage   string
+	admin    string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// ClientOptions configure how the Client connects to MarkLogic
+type ClientOptions struct {
+	// ManageURL is the base URL of the Manage App Server, for example http://marklogic-0.marklogic.default.svc.cluster.local:8002
+	ManageURL string
+	// AdminURL is the base URL of the Admin App Server, used to wait for restarts. Defaults to port 8001 of the ManageURL host.
+	AdminURL string
+	Username string
+	Password string
+	// CAFile is a PEM file with the certificates used to verify the App Servers when they use HTTPS
+	CAFile string
+	// Insecure skips the verification of the App Server certificates, as curl -k does in the chart scripts
+	Insecure bool
+	// Interval between attempts while waiting for MarkLogic and Timeout after which waiting fails
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// NewClient returns a Client using digest authentication with the given credentials
+func NewClient(options ClientOptions) (*Client, error) {
+	manage := strings.TrimSuffix(options.ManageURL, "/")
+	if manage == "" {
+		return nil, fmt.Errorf("the Manage URL is required")
+	}
+	admin := strings.TrimSuffix(options.AdminURL, "/")
+	if admin == "" {
+		admin = regexp.MustCompile(`:8002$`).ReplaceAllString(manage, ":8001")
+	}
+
+	client := req.C().SetCommonDigestAuth(options.Username, options.Password)
+	if options.Insecure {
+		client.EnableInsecureSkipVerify()
+	} else if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		client.SetTLSClientConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	c := &Client{http: client, manage: manage, admin: admin, Interval: options.Interval, Timeout: options.Timeout}
+	if c.Interval == 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Minute
+	}
+	return c, nil
+}
+
+// WaitReady waits until the Manage API answers, as the Job may start before MarkLogic has been bootstrapped
+func (c *Client) WaitReady() error {
+	return c.poll("the Manage API to be ready", func() (bool, error) {
+		resp, err := c.http.R().Get(c.manage + "/manage/v2")
+		return err == nil && resp.StatusCode == http.StatusOK, nil
+	})
+}
+
+// Properties returns the properties of a resource, or found false when the resource does not exist
+func (c *Client) Properties(path string) (properties map[string]interface{}, found bool, err error) {
+	resp, err := c.http.R().SetHeader("Accept", "application/json").Get(c.manage + withFormat(path))
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		properties, err := decodeObject(resp.Bytes())
+		if err != nil {
+			return nil, false, fmt.Errorf("GET %s: %w", path, err)
+		}
+		return properties, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, responseError(http.MethodGet, path, resp)
+	}
+}
+
+// Send sends a JSON payload to the Manage API and waits for MarkLogic to restart when the change requires it
+func (c *Client) Send(method string, path string, payload map[string]interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.R().SetContentType("application/json").SetBodyBytes(body).Send(method, c.manage+path)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusAccepted:
+		return c.waitForRestart(resp.String())
+	default:
+		return responseError(method, path, resp)
+	}
+}
+
+var lastStartupPattern = regexp.MustCompile(`last-startup[^0-9]*([0-9]{4}-[0-9]{2}-[0-9]{2}T[^"<]+)`)
+
+// waitForRestart waits until the Admin API reports a startup time later than the one in the response
+// of a change that restarts MarkLogic. Only a 200 answer holds a startup time, a host that is still
+// restarting answers with an error page.
+func (c *Client) waitForRestart(body string) error {
+	match := lastStartupPattern.FindStringSubmatch(body)
+	if match == nil {
+		return fmt.Errorf("no last-startup time in the response of a change that restarts MarkLogic: %s", strings.TrimSpace(body))
+	}
+	lastStartup, err := time.Parse(time.RFC3339Nano, match[1])
+	if err != nil {
+		return fmt.Errorf("invalid last-startup time in the response of a change that restarts MarkLogic: %w", err)
+	}
+	return c.poll("MarkLogic to restart", func() (bool, error) {
+		resp, err := c.http.R().Get(c.admin + "/admin/v1/timestamp")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false, nil
+		}
+		startup, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(resp.String()))
+		return err == nil && startup.After(lastStartup), nil
+	})
+}
+
+func (c *Client) poll(what string, done func() (bool, error)) error {
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", c.Timeout, what)
+		}
+		time.Sleep(c.Interval)
+	}
+}
+
+func withFormat(path string) string {
+	if strings.Contains(path, "?") {
+		return path + "&format=json"
+	}
+	return path + "?format=json"
+}
+
+func responseError(method string, path string, resp *req.Response) error {
+	message := strings.TrimSpace(resp.String())
+	if message == "" {
+		return fmt.Errorf("%s %s returned %d", method, path, resp.StatusCode)
+	}
+	return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, message)
+}
@@ -0,0 +1,81 @@
+// Command mlconfig applies a MarkLogic configuration bundle in the ml-config layout of ml-gradle through the Manage API.
+//
+// Usage:
+//
+//	mlconfig -dir /ml-config -manage-url http://marklogic-0.marklogic.default.svc.cluster.local:8002 \
+//	    -username-file /run/secrets/ml-secrets/username -password-file /run/secrets/ml-secrets/password [-dry-run]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marklogic/marklogic-kubernetes/tools/mlconfig"
+)
+
+func main() {
+	dir := flag.String("dir", "/ml-config", "directory of the configuration bundle")
+	manageURL := flag.String("manage-url", "", "base URL of the Manage App Server")
+	adminURL := flag.String("admin-url", "", "base URL of the Admin App Server, defaults to port 8001 of the Manage URL host")
+	usernameFile := flag.String("username-file", "/run/secrets/ml-secrets/username", "file holding the admin username")
+	passwordFile := flag.String("password-file", "/run/secrets/ml-secrets/password", "file holding the admin password")
+	caFile := flag.String("ca-file", "", "PEM file with the CA certificates of the App Servers")
+	insecure := flag.Bool("insecure", false, "skip the verification of the App Server certificates")
+	dryRun := flag.Bool("dry-run", false, "only print the differences between the bundle and the cluster")
+	timeout := flag.Duration("timeout", 10*time.Minute, "time to wait for MarkLogic to be ready or to restart")
+	flag.Parse()
+
+	if err := run(*dir, *manageURL, *adminURL, *usernameFile, *passwordFile, *caFile, *insecure, *dryRun, *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "mlconfig:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, manageURL, adminURL, usernameFile, passwordFile, caFile string, insecure, dryRun bool, timeout time.Duration) error {
+	bundle, err := mlconfig.LoadBundle(dir)
+	if err != nil {
+		return err
+	}
+	username, err := readSecret(usernameFile)
+	if err != nil {
+		return err
+	}
+	password, err := readSecret(passwordFile)
+	if err != nil {
+		return err
+	}
+	client, err := mlconfig.NewClient(mlconfig.ClientOptions{
+		ManageURL: manageURL,
+		AdminURL:  adminURL,
+		Username:  username,
+		Password:  password,
+		CAFile:    caFile,
+		Insecure:  insecure,
+		Timeout:   timeout,
+	})
+	if err != nil {
+		return err
+	}
+	if err := client.WaitReady(); err != nil {
+		return err
+	}
+
+	changes, err := mlconfig.Apply(client, bundle, mlconfig.Options{DryRun: dryRun, Out: os.Stdout})
+	if dryRun {
+		fmt.Println("dry run:", mlconfig.Summary(changes))
+	} else {
+		fmt.Println("applied:", mlconfig.Summary(changes))
+	}
+	return err
+}
+
+func readSecret(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
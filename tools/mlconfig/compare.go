@@ -0,0 +1,112 @@
+package mlconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// FieldDiff is a payload property whose value in the cluster differs from the bundle
+type FieldDiff struct {
+	Field   string
+	Current interface{}
+	Desired interface{}
+}
+
+// String formats the difference as "field: current -> desired"
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s -> %s", d.Field, formatValue(d.Current), formatValue(d.Desired))
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return "(not set)"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Compare returns the properties of the desired payload that differ from the current properties.
+// Properties the Manage API fills in with defaults but that are not in the desired payload are ignored,
+// as are the given write-only properties.
+func Compare(desired map[string]interface{}, current map[string]interface{}, writeOnly []string) []FieldDiff {
+	ignored := map[string]bool{}
+	for _, field := range writeOnly {
+		ignored[field] = true
+	}
+	var fields []string
+	for field := range desired {
+		if !ignored[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var diffs []FieldDiff
+	for _, field := range fields {
+		if !matches(desired[field], current[field]) {
+			diffs = append(diffs, FieldDiff{Field: field, Current: current[field], Desired: desired[field]})
+		}
+	}
+	return diffs
+}
+
+// matches reports whether the current value satisfies the desired one. Objects match when every desired
+// property matches, lists match when they hold matching items in any order, and scalars are compared by
+// their text so that 8010 and "8010" are the same, as the Manage API returns some numbers as strings.
+func matches(desired interface{}, current interface{}) bool {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		currentValue, ok := current.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for field, value := range desiredValue {
+			if !matches(value, currentValue[field]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		currentValue, ok := current.([]interface{})
+		if !ok {
+			// the Manage API returns a list with a single item as the item itself for some properties
+			if current == nil || len(desiredValue) != 1 {
+				return current == nil && len(desiredValue) == 0
+			}
+			currentValue = []interface{}{current}
+		}
+		if len(desiredValue) != len(currentValue) {
+			return false
+		}
+		used := make([]bool, len(currentValue))
+		for _, item := range desiredValue {
+			found := false
+			for i, candidate := range currentValue {
+				if !used[i] && matches(item, candidate) {
+					used[i] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return current == nil
+	default:
+		if current == nil {
+			return false
+		}
+		switch current.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+		return fmt.Sprint(desired) == fmt.Sprint(current)
+	}
+}